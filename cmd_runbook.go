@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	. "github.com/mesosphere-incubator/preflighter/util"
+)
+
+/**
+ * Implements `preflighter runbook <export|sync> ...`
+ */
+func cmdRunbook(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "export":
+			return cmdRunbookExport(args[1:])
+		case "sync":
+			return cmdRunbookSync(args[1:])
+		}
+	}
+	UxPrintError(fmt.Errorf("Usage: preflighter runbook <export|sync> [options]"))
+	return 1
+}
+
+/**
+ * Implements `preflighter runbook export [-o <file>] [-var name=value] <step>...`
+ */
+func cmdRunbookExport(args []string) int {
+	flags := flag.NewFlagSet("runbook export", flag.ExitOnError)
+	fOutput := flags.String("o", "", "write the checklist to the given file instead of the standard output")
+	fTitle := flags.String("title", "Runbook Checklist", "the title of the exported checklist")
+	fProvider := flags.String("provider", "", "the provider of the exported checklist")
+	var fVars stringList
+	flags.Var(&fVars, "var", "a `name=value` variable overriding the runbook variables (can be repeated)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: preflighter runbook export [options] <step>...\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		UxPrintError(fmt.Errorf("Please specify the runbook steps to export"))
+		return 1
+	}
+
+	localVars := make(map[string]string)
+	for _, v := range fVars {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			UxPrintError(fmt.Errorf("Invalid variable '%s' (expecting name=value)", v))
+			return 1
+		}
+		localVars[parts[0]] = parts[1]
+	}
+
+	runbook, err := CreateRunbookClientWithEnvConfig()
+	if err != nil {
+		UxPrintError(fmt.Errorf("Could not use runbook: %s", err.Error()))
+		return 1
+	}
+	runbook.Warn = UxPrintWarning
+
+	var checklist Checklist
+	for _, step := range flags.Args() {
+		items, err := runbook.ChecklistFromRunbook(step, localVars)
+		if err != nil {
+			UxPrintError(fmt.Errorf("Could not fetch checklist for step %s: %s", step, err.Error()))
+			return 1
+		}
+		checklist = append(checklist, items...)
+	}
+
+	var w io.Writer = os.Stdout
+	if *fOutput != "" {
+		f, err := os.Create(*fOutput)
+		if err != nil {
+			UxPrintError(fmt.Errorf("Could not create %s: %s", *fOutput, err.Error()))
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+
+	err = WriteRunbookChecklist(w, *fTitle, *fProvider, flags.Args(), checklist)
+	if err != nil {
+		UxPrintError(fmt.Errorf("Could not write checklist: %s", err.Error()))
+		return 1
+	}
+	if *fOutput != "" {
+		fmt.Printf("Exported %d items to %s\n", len(checklist), *fOutput)
+	}
+	return 0
+}
+
+/**
+ * Implements `preflighter runbook sync <outbox>`
+ */
+func cmdRunbookSync(args []string) int {
+	flags := flag.NewFlagSet("runbook sync", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: preflighter runbook sync <outbox>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		UxPrintError(fmt.Errorf("Please specify the outbox to send"))
+		return 1
+	}
+	outbox := flags.Arg(0)
+
+	UxSetPlain(!UxIsTerminal())
+
+	runbook, err := CreateRunbookClientWithEnvConfig()
+	if err != nil {
+		UxPrintError(fmt.Errorf("Could not use runbook: %s", err.Error()))
+		return 1
+	}
+
+	sent, pending, err := runbook.SyncOutbox(outbox, func(update *RunbookQueuedUpdate, err error) {
+		UxPrintCheck(fmt.Sprintf("%s/%s -> %s", update.Step, update.Item, update.Status), err)
+	})
+	if err != nil {
+		UxPrintError(err)
+		return 1
+	}
+
+	fmt.Printf("%d updates sent, %d left in %s\n", sent, pending, outbox)
+	if pending > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+
+	. "github.com/mesosphere-incubator/preflighter/util"
+)
+
+/**
+ * Implements `preflighter runbook-mock [-listen <addr>] [-token <token>] <fixture>`
+ */
+func cmdRunbookMock(args []string) int {
+	flags := flag.NewFlagSet("runbook-mock", flag.ExitOnError)
+	fListen := flags.String("listen", "127.0.0.1:8080", "the address to serve the runbook API on")
+	fToken := flags.String("token", "", "require the clients to authenticate with this token")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: preflighter runbook-mock [options] <fixture.yaml>\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		UxPrintError(fmt.Errorf("Please specify the fixture to serve"))
+		return 1
+	}
+
+	UxSetPlain(!UxIsTerminal())
+
+	fixture, err := LoadRunbookFixture(flags.Arg(0))
+	if err != nil {
+		UxPrintError(err)
+		return 1
+	}
+
+	mock := CreateRunbookMock(fixture)
+	mock.Token = *fToken
+	mock.OnRequest = func(verb string, path string, status int) {
+		fmt.Printf("%s %s -> %d\n", verb, path, status)
+	}
+
+	fmt.Printf("Serving the runbook API on http://%s (use RUNBOOK_URL=http://%s)\n", *fListen, *fListen)
+	err = http.ListenAndServe(*fListen, mock)
+	if err != nil {
+		UxPrintError(fmt.Errorf("Could not serve the runbook API: %s", err.Error()))
+		return 1
+	}
+	return 0
+}
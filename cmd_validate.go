@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	. "github.com/mesosphere-incubator/preflighter/util"
+)
+
+/**
+ * Implements `preflighter validate <checklist> [<checklist> ...]`
+ */
+func cmdValidate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: preflighter validate <checklist.yaml> [<checklist.yaml> ...]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		UxPrintError(fmt.Errorf("Please specify one or more checklists to validate"))
+		return 1
+	}
+
+	UxSetPlain(!UxIsTerminal())
+
+	failed := false
+	for _, fname := range flags.Args() {
+		issues := ValidateChecklistFile(fname)
+		UxPrintValidation(fname, issues)
+		if len(issues) > 0 {
+			failed = true
+		}
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
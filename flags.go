@@ -0,0 +1,19 @@
+package main
+
+import (
+	"strings"
+)
+
+/**
+ * A flag value that can be repeated to collect multiple values
+ */
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
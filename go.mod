@@ -4,8 +4,8 @@ go 1.13
 
 require (
 	github.com/briandowns/spinner v1.10.0
-	github.com/imdario/mergo v0.3.9
 	github.com/lithammer/dedent v1.1.0
 	github.com/logrusorgru/aurora v0.0.0-20200102142835-e9ef32dff381
-	gopkg.in/yaml.v2 v2.2.8
+	github.com/yuin/goldmark v1.6.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -4,25 +4,90 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"os/exec"
 	"strings"
 
-	. "github.com/logrusorgru/aurora"
 	. "github.com/mesosphere-incubator/preflighter/util"
 )
 
+/**
+ * Prints the items of the given checklists
+ */
+func listChecklists(checklistFiles []*ChecklistFile) {
+	i := 0
+	for _, list := range checklistFiles {
+		fmt.Printf("In %s (%s):\n", list.Filename, list.Title)
+		for _, item := range list.Checklist {
+			i += 1
+			fmt.Printf(" %2d. %s\n", i, item.Title)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("%d items in total\n", i)
+}
+
 func main() {
 	var runbook *RunbookClient = nil
 	var err error = nil
 
+	// Dispatch sub-commands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(cmdValidate(os.Args[2:]))
+		case "runbook-mock":
+			os.Exit(cmdRunbookMock(os.Args[2:]))
+		case "runbook":
+			os.Exit(cmdRunbook(os.Args[2:]))
+		}
+	}
+
 	fTempDir := flag.String("temp", "", "keep temporary files in the given directory")
 	fSkipPtr := flag.Int("s", 0, "the number of items to skip")
+	fResume := flag.Bool("resume", false, "resume the previous session of the same checklist from the first unresolved item")
 	fListPtr := flag.Bool("l", false, "list the items and exit")
 	fAutoPtr := flag.Bool("a", false, "run the tests unattended")
+	fContinue := flag.Bool("continue-on-fail", false, "do not offer to re-check failed items right away, but revisit all the failed items before the final verdict")
+	fTui := flag.Bool("tui", false, "use a full-screen interface where the items can be checked in any order")
+	fJobs := flag.Int("j", 1, "the number of checks to run concurrently when running unattended")
+	fUndecided := flag.String("undecided", "", "how to resolve items without automatic checks when running unattended: skip, fail or pass (default: skip with -a, fail without a terminal)")
+	fTimeout := flag.Duration("timeout", 0, "the default timeout for items that do not define one (overrides the checklist default)")
+	fOutbox := flag.String("outbox", "", "queue the runbook updates in the given file, to send them later with preflighter runbook sync")
+	var fReports stringList
+	flag.Var(&fReports, "report", "write a report of the run to the given file, as JUnit XML if it ends in .xml or JSON otherwise (can be repeated)")
 	flag.Parse()
 	if len(flag.Args()) == 0 {
 		UxPrintError(fmt.Errorf("Please specify one or more checklists to process"))
-		return
+		os.Exit(1)
+	}
+
+	// Without a terminal the operator cannot be prompted, so fall back to
+	// plain output and unattended checks
+	UxSetPlain(!UxIsTerminal())
+	unattended := *fAutoPtr
+	noTerminal := !unattended && !UxIsInteractive()
+	if noTerminal {
+		unattended = true
+	}
+	if *fTui && unattended {
+		UxPrintError(fmt.Errorf("The -tui interface requires a terminal and cannot be used unattended"))
+		os.Exit(1)
+	}
+
+	undecided := OutcomeNoChecks
+	switch *fUndecided {
+	case "":
+		if noTerminal {
+			undecided = OutcomeFail
+		}
+	case "skip":
+		undecided = OutcomeNoChecks
+	case "fail":
+		undecided = OutcomeFail
+	case "pass":
+		undecided = OutcomePass
+	default:
+		UxPrintError(fmt.Errorf("Invalid -undecided value '%s' (expecting skip, fail or pass)", *fUndecided))
+		os.Exit(1)
 	}
 
 	// Read the checklists from the given arguments
@@ -42,7 +107,7 @@ func main() {
 		checklist, err := LoadChecklist(fname)
 		if err != nil {
 			UxPrintError(err)
-			return
+			os.Exit(1)
 		}
 
 		// Check if runbook is needed
@@ -61,48 +126,70 @@ func main() {
 	// Create runbook instance if needed
 	if useRunbook {
 		runbook, err = CreateRunbookClientWithEnvConfig()
+		if err != nil && *fOutbox != "" && os.Getenv("RUNBOOK_KEY") == "" {
+			// Without credentials the updates can still be queued
+			runbook, err = CreateOfflineRunbookClient(*fOutbox), nil
+		}
 		if err != nil {
 			UxPrintError(fmt.Errorf("Could not use runbook: %s", err.Error()))
 			os.Exit(1)
 		}
+		if *fOutbox != "" {
+			runbook.QueueUpdates(*fOutbox)
+		}
+		runbook.Warn = UxPrintWarning
 	}
 
-	// Check for required environment variables
-	failed := false
-	for _, file := range checklistFiles {
-		for key, value := range file.Env {
-			if strings.HasPrefix(value, "${") {
-				if len(value) < 3 {
-					file.Env[key] = ""
-					continue
-				}
-
-				cmd := value[2 : len(value)-1]
-				out, err := exec.Command("bash", "-c", cmd).Output()
-				if err != nil {
-					failed = true
-					UxPrintError(fmt.Errorf("Unable to execute '%s': %s", cmd, err.Error()))
-				}
-
-				file.Env[key] = strings.TrimRight(string(out), "\n\r\t ")
+	// The items can be listed right away, unless the runbook items need the
+	// variables of the configuration
+	if *fListPtr && !useRunbook {
+		listChecklists(checklistFiles)
+		os.Exit(0)
+	}
 
-			} else if value == "<" {
-				if os.Getenv(key) == "" {
-					failed = true
-					UxPrintError(fmt.Errorf("Missing required %s environment variable", key))
-				}
-			}
+	// Prepare configuration
+	config, err := CreateConfig()
+	if err != nil {
+		UxPrintError(err)
+		os.Exit(1)
+	}
+	if *fTempDir != "" {
+		config.UserTempDir = *fTempDir
+	}
+	if !unattended {
+		config.PromptVar = UxPromptVar
+	}
+	for _, checklist := range checklistFiles {
+		err = config.AddChecklistFile(checklist)
+		if err != nil {
+			UxPrintError(err)
+			os.Exit(1)
 		}
 	}
-	if failed {
+	errs := config.ResolveVars()
+	if len(errs) > 0 {
+		for _, err := range errs {
+			UxPrintError(err)
+		}
+		os.Exit(1)
+	}
+
+	notifiers, err := config.CreateNotifiers()
+	if err != nil {
+		UxPrintError(err)
 		os.Exit(1)
 	}
 
 	// If we have runbook items in the checklist append it now
 	for _, list := range checklistFiles {
 		if len(list.RunbookSteps) > 0 {
+			// The local variables of the checklist override the runbook ones
+			localVars := make(map[string]string)
+			for name := range list.Vars {
+				localVars[name] = config.Env[name]
+			}
 			for _, step := range list.RunbookSteps {
-				checklist, err := runbook.ChecklistFromRunbook(step)
+				checklist, err := runbook.ChecklistFromRunbook(step, localVars)
 				if err != nil {
 					UxPrintError(fmt.Errorf("Could not fetch checklist for step %s: %s", step, err.Error()))
 					os.Exit(1)
@@ -115,42 +202,16 @@ func main() {
 
 	// Check if we should just list and exit
 	if *fListPtr {
-		i := 0
-		for _, list := range checklistFiles {
-			fmt.Printf("In %s (%s):\n", list.Filename, list.Title)
-			for _, item := range list.Checklist {
-				i += 1
-				fmt.Printf(" %2d. %s\n", i, item.Title)
-			}
-			fmt.Println()
-		}
-		fmt.Printf("%d items in total\n", i)
+		listChecklists(checklistFiles)
 		os.Exit(0)
 	}
 
-	// Prepare configuration
-	config, err := CreateConfig()
-	if err != nil {
-		UxPrintError(err)
-		return
-	}
-	if *fTempDir != "" {
-		config.UserTempDir = *fTempDir
-	}
-	for _, checklist := range checklistFiles {
-		err = config.AddChecklistFile(checklist)
-		if err != nil {
-			UxPrintError(err)
-			return
-		}
-	}
-
 	// Create the runner component that executes scripts in a well-prepared
 	// environment.
 	runner, err := CreateRunner(config)
 	if err != nil {
 		UxPrintError(err)
-		return
+		os.Exit(1)
 	}
 
 	// Check if all the required utilities exst
@@ -160,80 +221,304 @@ func main() {
 		for _, name := range missing {
 			fmt.Printf(" ‣ Did not find '%s'\n", name)
 		}
-		return
+		runner.Cleanup()
+		os.Exit(1)
 	}
 
 	fmt.Println("==========================================")
 	fmt.Printf(" %s Pre-Flight Checklist\n", checklistFiles[0].Title)
 	fmt.Println("==========================================")
 	fmt.Println()
+	if noTerminal {
+		fmt.Printf("Not running in a terminal, checking the items unattended (undecided items: %s)\n", undecided)
+		fmt.Println()
+	}
 
 	var allItems []ChecklistItem
 	for _, list := range checklistFiles {
-		for _, item := range list.Checklist {
-			allItems = append(allItems, item)
+		allItems = append(allItems, list.ItemsWithTimeout(*fTimeout)...)
+	}
+
+	report := CreateReport(checklistFiles[0].Title, unattended)
+
+	// The session is persisted in a stable location, so it can be resumed
+	// even if the cache directory was a temporary one
+	sessionDir := runner.CacheDir
+	if *fTempDir == "" {
+		sessionDir, err = DefaultSessionDir()
+		if err != nil {
+			UxPrintError(err)
+			os.Exit(1)
+		}
+	}
+	sessionFile := SessionFilename(sessionDir, allItems)
+	session := CreateSession(sessionFile, allItems)
+	resumed := 0
+	if *fResume {
+		prev, err := LoadSession(sessionFile, allItems)
+		if err != nil {
+			UxPrintError(err)
+			os.Exit(1)
+		}
+		if prev == nil {
+			fmt.Println("There is no previous session for this checklist, starting from the beginning")
+			fmt.Println()
+		} else {
+			session = prev
+			resumed = session.ResolvedCount()
 		}
 	}
 
 	failure := false
-	for _, item := range allItems[:*fSkipPtr] {
-		UxBlankItem(&item)
+	skip := *fSkipPtr
+	if resumed > skip {
+		skip = resumed
 	}
-	for _, item := range allItems[*fSkipPtr:] {
-		if failure {
-			UxSkipItem(&item, "ABORTED")
+	for idx, item := range allItems[:skip] {
+		if idx < resumed {
+			res := CheckResult{
+				Stdout:  session.Items[idx].Value,
+				Outcome: ParseOutcome(session.Items[idx].Outcome),
+			}
+			UxResumedItem(&item, res.Stdout, res.Outcome)
+			report.AddItem(&item, &res)
 		} else {
+			UxBlankItem(&item)
+		}
+	}
 
-			if *fAutoPtr {
-				// Perform passive checks if we are running in auto mode
-				if !CanCheckItem(&item) {
-					UxSkipItem(&item, "NO CHECKS")
-				} else {
-					value, serr, ok, err := RunItemCheck(&item, runner)
-					if err != nil {
-						UxFailItem(&item, err.Error(), serr)
-						failure = true
-					} else if !ok {
-						UxFailItem(&item, value, serr)
-						failure = true
-					} else {
-						UxPassItem(&item, value)
-					}
-				}
+	// Persist the outcome of every item as soon as it's known
+	recordItem := func(idx int, res *CheckResult) {
+		err := session.Record(skip+idx, res)
+		if err != nil {
+			UxPrintError(err)
+		}
+	}
 
+	// Report the outcome of the items linked to a runbook checklist item
+	updateRunbook := func(item *ChecklistItem, res *CheckResult) error {
+		if runbook == nil || item.RunbookID == "" {
+			return nil
+		}
+		switch res.Outcome {
+		case OutcomeFail, OutcomeTimeout:
+			reason := "Script failed with:\n```\n" + res.Stdout + "\n---\n" + res.Stderr + "\n```\n"
+			return runbook.ChecklistItemUpdate(item.RunbookStep, item.RunbookID, RunbookFailed, reason)
+		case OutcomeSkip:
+			return runbook.ChecklistItemUpdate(item.RunbookStep, item.RunbookID, RunbookSkipped, res.Reason)
+		default:
+			return runbook.ChecklistItemUpdate(item.RunbookStep, item.RunbookID, RunbookCompleted, "")
+		}
+	}
+
+	// Notify the failure of an item in the background
+	notifyItem := func(item *ChecklistItem, res *CheckResult) {
+		if res.Outcome != OutcomeFail && res.Outcome != OutcomeTimeout {
+			return
+		}
+		reason := res.Reason
+		if reason == "" {
+			reason = res.ExpectDetails
+		}
+		notifiers.Queue(&NotifyEvent{
+			Event:     NotifyItemFailed,
+			Checklist: checklistFiles[0].Title,
+			Item:      item.Title,
+			Outcome:   res.Outcome.String(),
+			Value:     res.Stdout,
+			Reason:    reason,
+		})
+	}
+	printErrors := func(errs []error) {
+		for _, err := range errs {
+			UxPrintError(err)
+		}
+	}
+
+	items := allItems[skip:]
+	if unattended {
+		// Perform passive checks if we are running in auto mode
+		RunItemChecks(items, runner, *fJobs, undecided, func(idx int, check *ItemCheck) {
+			item := &items[idx]
+			if check.Result.Outcome == OutcomeBlocked {
+				UxSkipItem(item, "BLOCKED")
 			} else {
-				// Otherwise go through the UI
-				ok, result := UxCheckItem(&item, runner)
-				if !ok {
-					failure = true
-					if item.RunbookID != "" {
-						reason := "Script failed with:\n```\n" + result.Stdout + "\n---\n" + result.Stderr + "\n```\n"
-						runbook.ChecklistItemUpdate(
-							item.RunbookStep,
-							item.RunbookID,
-							2, // Failed
-							reason,
-						)
+				UxAutoItem(item, &check.Result, check.Err)
+			}
+
+			report.AddItem(item, &check.Result)
+			recordItem(idx, &check.Result)
+			notifyItem(item, &check.Result)
+			switch check.Result.Outcome {
+			case OutcomeFail, OutcomeTimeout:
+				failure = true
+			}
+		})
+
+	} else if *fTui {
+		// Let the operator check the items in any order, and collect the
+		// results once done
+		runbookErrors := make(map[int]error)
+		results, err := UxRunTUI(checklistFiles[0].Title, items, runner, func(idx int, res *CheckResult) error {
+			recordItem(idx, res)
+			notifyItem(&items[idx], res)
+			err := updateRunbook(&items[idx], res)
+			if err != nil {
+				runbookErrors[idx] = err
+			} else {
+				delete(runbookErrors, idx)
+			}
+			return err
+		})
+		if err != nil {
+			UxPrintError(err)
+			printErrors(notifiers.Flush())
+			os.Exit(1)
+		}
+
+		graph := CreateDependencyGraph(items)
+		outcomes := make([]Outcome, len(items))
+		for idx := range items {
+			res := &results[idx]
+			if res.Outcome == OutcomeNone {
+				res.Outcome = OutcomeAborted
+				if graph.BlockedBy(idx, outcomes) >= 0 {
+					res.Outcome = OutcomeBlocked
+					recordItem(idx, res)
+				}
+			}
+			outcomes[idx] = res.Outcome
+
+			UxResolvedItem(&items[idx], res)
+			if err, ok := runbookErrors[idx]; ok {
+				UxRunbookUpdateFailed(&items[idx], err)
+			}
+			report.AddItem(&items[idx], res)
+			switch res.Outcome {
+			case OutcomeFail, OutcomeTimeout, OutcomeAborted:
+				failure = true
+			}
+		}
+
+	} else {
+		// Otherwise go through the UI, skipping the items that depend on
+		// failed items
+		UxSetContinueOnFail(*fContinue)
+		graph := CreateDependencyGraph(items)
+		results := make([]CheckResult, len(items))
+		outcomes := make([]Outcome, len(items))
+		quit := false
+		for idx, item := range items {
+			if quit {
+				UxSkipItem(&item, "ABORTED")
+				results[idx].Outcome = OutcomeAborted
+				outcomes[idx] = OutcomeAborted
+				continue
+			}
+			if graph.BlockedBy(idx, outcomes) >= 0 {
+				UxSkipItem(&item, "BLOCKED")
+				results[idx].Outcome = OutcomeBlocked
+				outcomes[idx] = OutcomeBlocked
+				recordItem(idx, &results[idx])
+				continue
+			}
+
+			results[idx] = UxCheckItem(&item, runner)
+			outcomes[idx] = results[idx].Outcome
+			if results[idx].Outcome == OutcomeAborted {
+				// The operator has quit, leaving this item unresolved
+				quit = true
+				continue
+			}
+
+			recordItem(idx, &results[idx])
+			if err := updateRunbook(&item, &results[idx]); err != nil {
+				UxRunbookUpdateFailed(&item, err)
+			}
+			notifyItem(&item, &results[idx])
+			quit = results[idx].Quit
+		}
+
+		// Give the operator the chance to fix the failed items and check
+		// them again, together with the items they were blocking
+		revisit := 0
+		for _, o := range outcomes {
+			switch o {
+			case OutcomeFail, OutcomeTimeout, OutcomeBlocked:
+				revisit++
+			}
+		}
+		if *fContinue && !quit && revisit > 0 {
+			fmt.Println()
+			if UxConfirm(fmt.Sprintf("%d items have failed or were blocked. Do you want to revisit them?", revisit)) {
+				fmt.Println()
+				for idx, item := range items {
+					switch outcomes[idx] {
+					case OutcomeFail, OutcomeTimeout, OutcomeBlocked:
+					default:
+						continue
+					}
+					if graph.BlockedBy(idx, outcomes) >= 0 {
+						UxSkipItem(&item, "BLOCKED")
+						continue
+					}
+
+					result := UxCheckItem(&item, runner)
+					if result.Outcome == OutcomeAborted {
+						// Quitting the revisit keeps the previous outcomes
+						break
+					}
+					results[idx] = result
+					outcomes[idx] = result.Outcome
+					recordItem(idx, &result)
+					if err := updateRunbook(&item, &result); err != nil {
+						UxRunbookUpdateFailed(&item, err)
+					}
+					notifyItem(&item, &result)
+					if result.Quit {
+						break
 					}
-				} else {
-					runbook.ChecklistItemUpdate(
-						item.RunbookStep,
-						item.RunbookID,
-						1, // Completed
-						"",
-					)
 				}
 			}
 		}
+
+		for idx := range items {
+			report.AddItem(&items[idx], &results[idx])
+			switch outcomes[idx] {
+			case OutcomeFail, OutcomeTimeout, OutcomeAborted:
+				failure = true
+			}
+		}
+	}
+
+	report.Complete(!failure)
+
+	failed := 0
+	for _, item := range report.Items {
+		switch item.Decision {
+		case "fail", "timeout", "aborted":
+			failed++
+		}
+	}
+	printErrors(notifiers.Flush())
+	printErrors(notifiers.Notify(&NotifyEvent{
+		Event:     NotifyVerdict,
+		Checklist: checklistFiles[0].Title,
+		Passed:    !failure,
+		Failed:    failed,
+		Total:     len(report.Items),
+	}))
+
+	for _, filename := range fReports {
+		err = report.WriteFile(filename)
+		if err != nil {
+			UxPrintError(err)
+		}
 	}
 
+	UxPrintVerdict(!failure)
 	if failure {
-		fmt.Println()
-		fmt.Println("🚨 ", Bold(Red("There was a failed item. You are not clear to continue")))
 		os.Exit(1)
-	} else {
-		fmt.Println()
-		fmt.Println("🍺 ", Bold("All checks are passing. You are clear to continue"))
-		os.Exit(0)
 	}
+	os.Exit(0)
 }
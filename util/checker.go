@@ -3,37 +3,146 @@ package util
 import (
 	"fmt"
 	"os/exec"
-	"regexp"
 	"strings"
+	"time"
 )
 
 /**
- * Runs the given item script and returns the stdount/stderr
+ * The outcome of executing and checking a checklist item
  */
-func RunItemScript(item *ChecklistItem, runner *Runner) (string, string, error) {
-	sout, serr, err := runner.Run(item.Script)
-	if err != nil {
-		if xerr, ok := err.(*exec.ExitError); ok {
-			err = fmt.Errorf("Exited with %d", xerr.ExitCode())
+type CheckResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Duration time.Duration
+	TimedOut bool
+
+	// The result of the automatic checks ("pass", "fail" or "" if the item
+	// was not checked) and the details explaining a failure
+	Expect        string
+	ExpectDetails string
+
+	// How the item was resolved, and the reason given by the operator
+	Outcome Outcome
+	Reason  string
+
+	// Set when the operator has resolved the item and asked to stop the run
+	Quit bool
+
+	// All the attempts made, if the item was retried
+	Attempts []CheckAttempt
+}
+
+/**
+ * The record of a single attempt to run an item
+ */
+type CheckAttempt struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Duration time.Duration
+	Error    string
+	Details  string
+}
+
+/**
+ * Runs the given item script and returns the stdount/stderr, optionally
+ * streaming the stderr lines to the given callback
+ */
+func RunItemScript(item *ChecklistItem, runner *Runner, onStderr func(string)) (CheckResult, error) {
+	var res CheckResult
+
+	started := time.Now()
+	sout, serr, err := runner.RunWithOptions(item.Script, RunOptions{
+		Timeout:        time.Duration(item.Timeout),
+		StderrCallback: onStderr,
+	})
+	res.Duration = time.Now().Sub(started)
+	if xerr, ok := err.(*exec.ExitError); ok {
+		res.ExitCode = xerr.ExitCode()
+		err = nil
+	} else if err != nil {
+		res.ExitCode = -1
+	}
+
+	// Some probes are expected to exit with a non-zero code
+	if err == nil && !item.ExpectExit.Accepts(res.ExitCode) {
+		if len(item.ExpectExit) == 0 {
+			err = fmt.Errorf("Exited with %d", res.ExitCode)
+		} else {
+			err = fmt.Errorf("Exited with %d (expecting %v)", res.ExitCode, []int(item.ExpectExit))
 		}
 	}
 
-	sout = strings.Trim(sout, "\r\n\t ")
-	return sout, serr, err
+	res.Stdout = strings.Trim(sout, "\r\n\t ")
+	res.Stderr = serr
+	res.TimedOut = IsTimeout(err)
+	return res, err
+}
+
+/**
+ * Checks if the error returned by the check functions is a timeout
+ */
+func IsTimeout(err error) bool {
+	_, ok := err.(*TimeoutError)
+	return ok
 }
 
 func CanCheckItem(item *ChecklistItem) bool {
-	return item.ExpectScript != "" || item.ExpectMatch != ""
+	return item.ExpectScript != "" ||
+		item.ExpectMatch != "" ||
+		item.NotMatch != "" ||
+		item.ExpectNumber != "" ||
+		item.ExpectVersion != "" ||
+		len(item.ExpectOneOf) > 0 ||
+		len(item.ExpectJSON) > 0 ||
+		len(item.ExpectExit) > 0 ||
+		item.ExpectStderr != ""
 }
 
 /**
  * Runs the item's automatic checks
  */
-func checkItemValue(item *ChecklistItem, runner *Runner, value string) (bool, string, error) {
+func checkItemValue(item *ChecklistItem, runner *Runner, res *CheckResult) (bool, string, error) {
+	if !CanCheckItem(item) {
+		return false, "No expect condition", nil
+	}
+	value := res.Stdout
+
+	// Evaluate all the declarative expectations
+	matchers, err := itemMatchers(item)
+	if err != nil {
+		return false, "", err
+	}
+	var failures []string
+	for _, m := range matchers {
+		if reason := m(value); reason != "" {
+			failures = append(failures, reason)
+		}
+	}
+
+	// The stderr is checked independently from the value
+	if item.ExpectStderr != "" {
+		m, err := regexMatcher(item.ExpectStderr, false)
+		if err != nil {
+			return false, "", err
+		}
+		if reason := m(strings.Trim(res.Stderr, "\r\n\t ")); reason != "" {
+			failures = append(failures, "stderr "+reason)
+		}
+	}
+
+	if len(failures) > 0 {
+		return false, strings.Join(failures, "\n") + "\n", nil
+	}
+
 	// If there is a script, call-out to the given script to compute
 	// if the result obtained is valid
 	if item.ExpectScript != "" {
-		_, serr, err := runner.RunWithValue(item.ExpectScript, value)
+		_, serr, err := runner.RunWithOptions(item.ExpectScript, RunOptions{
+			Value:   value,
+			Timeout: time.Duration(item.Timeout),
+		})
 		if err != nil {
 			if xerr, ok := err.(*exec.ExitError); ok {
 				if xerr.ExitCode() != 0 {
@@ -45,33 +154,123 @@ func checkItemValue(item *ChecklistItem, runner *Runner, value string) (bool, st
 		return true, serr, nil
 	}
 
-	// If there is a regular expression, check now
-	if item.ExpectMatch != "" {
-		re := regexp.MustCompile(item.ExpectMatch)
-		return re.MatchString(value),
-			fmt.Sprintf("      Regex: %s\nDon't match: \"%s\"\n", item.ExpectMatch, value),
-			nil
+	return true, "", nil
+}
+
+/**
+ * Runs the item's automatic checks
+ */
+func RunItemCheck(item *ChecklistItem, runner *Runner) (CheckResult, bool, error) {
+	return RunItemAttempts(item, runner, nil, true)
+}
+
+/**
+ * The delay before the given retry attempt (starting from 1), doubling on
+ * every attempt up to a minute
+ */
+func retryDelay(item *ChecklistItem, attempt int) time.Duration {
+	delay := time.Duration(item.RetryDelay)
+	if delay == 0 {
+		delay = time.Second
 	}
+	for i := 1; i < attempt && delay < time.Minute; i++ {
+		delay *= 2
+	}
+	if delay > time.Minute {
+		delay = time.Minute
+	}
+	return delay
+}
+
+/**
+ * Runs the item script, and optionally the item's automatic checks, as many
+ * times as the item allows until it succeeds. A failed script is always
+ * retried, while a value not matching the expectations is retried only if
+ * `retry_until_match` is set. The result of the last attempt is returned,
+ * with all the attempts recorded in it.
+ */
+func RunItemAttempts(item *ChecklistItem, runner *Runner, onStderr func(string), check bool) (CheckResult, bool, error) {
+	var attempts []CheckAttempt
+	started := time.Now()
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			delay := retryDelay(item, attempt)
+			if onStderr != nil {
+				onStderr(fmt.Sprintf("[retry] Attempt %d of %d in %s", attempt+1, item.Retries+1, delay))
+			}
+			time.Sleep(delay)
+		}
+
+		res, ok, err := runItemAttempt(item, runner, onStderr, check)
+		record := CheckAttempt{
+			Stdout:   res.Stdout,
+			Stderr:   res.Stderr,
+			ExitCode: res.ExitCode,
+			Duration: res.Duration,
+			Details:  res.ExpectDetails,
+		}
+		if err != nil {
+			record.Error = err.Error()
+		}
+		attempts = append(attempts, record)
 
-	return false, "No expect condition", nil
+		retry := err != nil || (!ok && check && item.RetryUntilMatch)
+		if !retry || attempt >= item.Retries {
+			res.Attempts = attempts
+			res.Duration = time.Now().Sub(started)
+			return res, ok, err
+		}
+	}
 }
 
 /**
- * Runs the item's automatic checks
+ * Runs a single attempt of the item script and checks
  */
-func RunItemCheck(item *ChecklistItem, runner *Runner) (string, string, bool, error) {
-	value, serr, err := RunItemScript(item, runner)
-	if err != nil {
-		return "", "", false, err
+func runItemAttempt(item *ChecklistItem, runner *Runner, onStderr func(string), check bool) (CheckResult, bool, error) {
+	res, err := RunItemScript(item, runner, onStderr)
+	if err != nil || !check {
+		return res, err == nil, err
 	}
 
-	ok, cserr, err := checkItemValue(item, runner, value)
+	ok, cserr, err := checkItemValue(item, runner, &res)
 	if err != nil {
-		return "", "", false, err
+		return res, false, err
 	}
 	if !ok {
-		return value, cserr, false, nil
+		res.Expect = "fail"
+		res.ExpectDetails = cserr
+		return res, false, nil
 	}
 
-	return value, serr, ok, nil
+	res.Expect = "pass"
+	return res, ok, nil
+}
+
+/**
+ * Runs the item's automatic checks and resolves the outcome of the item
+ * without any operator intervention. Items without automatic checks need a
+ * human decision, so they are resolved with the `undecided` outcome: with
+ * OutcomeNoChecks they are not run at all, otherwise their script is run and
+ * the outcome is applied if the script succeeds.
+ */
+func AutoCheckItem(item *ChecklistItem, runner *Runner, undecided Outcome) (CheckResult, error) {
+	if !CanCheckItem(item) && undecided == OutcomeNoChecks {
+		return CheckResult{Outcome: OutcomeNoChecks}, nil
+	}
+
+	res, ok, err := RunItemAttempts(item, runner, nil, CanCheckItem(item))
+	if IsTimeout(err) {
+		res.Outcome = OutcomeTimeout
+	} else if err != nil || !ok {
+		res.Outcome = OutcomeFail
+	} else if !CanCheckItem(item) {
+		res.Outcome = undecided
+		if undecided == OutcomeFail {
+			res.ExpectDetails = "The item requires an operator decision\n"
+		}
+	} else {
+		res.Outcome = OutcomePass
+	}
+	return res, err
 }
@@ -1,47 +1,332 @@
 package util
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
 
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
+/**
+ * A time.Duration that can be parsed from a YAML string (ex. "30s")
+ */
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var text string
+	err := unmarshal(&text)
+	if err != nil {
+		return err
+	}
+
+	value, err := time.ParseDuration(text)
+	if err != nil {
+		return fmt.Errorf("Invalid duration '%s': %s", text, err.Error())
+	}
+
+	*d = Duration(value)
+	return nil
+}
+
+/**
+ * A list of exit codes that can be parsed either from a single YAML integer
+ * or from a list of integers
+ */
+type ExitCodes []int
+
+func (c *ExitCodes) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var single int
+	if err := unmarshal(&single); err == nil {
+		*c = ExitCodes{single}
+		return nil
+	}
+
+	var list []int
+	err := unmarshal(&list)
+	if err != nil {
+		return fmt.Errorf("Expecting an exit code or a list of exit codes")
+	}
+	*c = ExitCodes(list)
+	return nil
+}
+
+/**
+ * Checks if the given exit code is expected, defaulting to 0 if the list
+ * is empty
+ */
+func (c ExitCodes) Accepts(code int) bool {
+	if len(c) == 0 {
+		return code == 0
+	}
+	for _, expected := range c {
+		if expected == code {
+			return true
+		}
+	}
+	return false
+}
+
 type ChecklistItem struct {
+	ID     string `yaml:"id"`
 	Title  string
 	Script string
 
-	ExpectMatch  string `yaml:"expect"`
-	ExpectScript string `yaml:"expect_script"`
+	DependsOn []string `yaml:"depends_on"`
+
+	ExpectMatch   string          `yaml:"expect"`
+	ExpectScript  string          `yaml:"expect_script"`
+	NotMatch      string          `yaml:"not_match"`
+	ExpectNumber  string          `yaml:"expect_number"`
+	ExpectVersion string          `yaml:"expect_version"`
+	ExpectOneOf   []string        `yaml:"expect_one_of"`
+	ExpectJSON    []JSONAssertion `yaml:"expect_json"`
+	ExpectExit    ExitCodes       `yaml:"expect_exit"`
+	ExpectStderr  string          `yaml:"expect_stderr"`
+
+	Timeout Duration `yaml:"timeout"`
+
+	// Retry failed attempts, and optionally attempts that do not match
+	// the expectations
+	Retries         int      `yaml:"retries"`
+	RetryDelay      Duration `yaml:"retry_delay"`
+	RetryUntilMatch bool     `yaml:"retry_until_match"`
+
+	// Instantiate the given template, with the given arguments
+	Use  string            `yaml:"use"`
+	With map[string]string `yaml:"with"`
 
 	RunbookID   string `yaml:"runbook_id"`
 	RunbookStep string `yaml:"runbook_step"`
+	Filename    string `yaml:"-"`
+
+	// The keys given in the checklist file, telling the fields set to a zero
+	// value apart from the missing ones
+	keys map[string]bool
+}
+
+func (item *ChecklistItem) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plainChecklistItem ChecklistItem
+	var plain plainChecklistItem
+	err := unmarshal(&plain)
+	if err != nil {
+		return err
+	}
+	*item = ChecklistItem(plain)
+
+	var keys map[string]interface{}
+	err = unmarshal(&keys)
+	if err != nil {
+		return err
+	}
+	item.keys = make(map[string]bool)
+	for key := range keys {
+		item.keys[key] = true
+	}
+	return nil
+}
+
+/**
+ * Checks if the field with the given YAML key is set in the item. The items
+ * that were not read from a file only have their non-zero fields set.
+ */
+func (item *ChecklistItem) hasField(key string) bool {
+	if item.keys == nil {
+		field, ok := checklistItemFields[key]
+		return ok && !reflect.ValueOf(item).Elem().FieldByIndex(field.Index).IsZero()
+	}
+	return item.keys[key]
 }
 
+// The fields of the checklist items, by YAML key
+var checklistItemFields = yamlFields(reflect.TypeOf(ChecklistItem{}))
+
 type Checklist = []ChecklistItem
 
 type ChecklistFile struct {
 	Title        string
+	Include      []string
+	Templates    map[string]ChecklistItem
 	Checklist    Checklist
+	Provider     string
 	Libs         []string
-	Env          map[string]string `yaml:"vars"`
-	RequireTools []string          `yaml:"require_tools"`
-	RunbookSteps []string          `yaml:"runbook_steps"`
-	Filename     string            `yaml:"-"`
+	Vars         map[string]VarSpec `yaml:"vars"`
+	RequireTools []string           `yaml:"require_tools"`
+	Secrets      []string           `yaml:"secrets"`
+	RunbookSteps []string           `yaml:"runbook_steps"`
+	Notify       []NotifySpec       `yaml:"notify"`
+	Timeout      Duration           `yaml:"timeout"`
+	Filename     string             `yaml:"-"`
 }
 
 func LoadChecklist(filename string) (*ChecklistFile, error) {
+	cf, err := loadChecklistFile(filename, nil, make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+
+	cf.Checklist, err = ExpandTemplates(cf.Checklist, cf.Templates)
+	if err != nil {
+		return nil, fmt.Errorf("Could not expand templates in %s: %s", filename, err.Error())
+	}
+
+	for i := range cf.Checklist {
+		err = ValidateItem(&cf.Checklist[i])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid item in %s: %s", filename, err.Error())
+		}
+	}
+
+	cf.Checklist, err = SortChecklist(cf.Checklist)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid dependencies in %s: %s", filename, err.Error())
+	}
+
+	_, err = varOrder(cf.Vars)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid vars in %s: %s", filename, err.Error())
+	}
+
+	return cf, nil
+}
+
+/**
+ * Returns the items of the checklist, with the default timeout of the file
+ * applied to the items that do not define one. A non-zero `override` (the
+ * `-timeout` option) replaces the default of the file, but not the timeout of
+ * the items.
+ */
+func (cf *ChecklistFile) ItemsWithTimeout(override time.Duration) Checklist {
+	timeout := cf.Timeout
+	if override != 0 {
+		timeout = Duration(override)
+	}
+
+	items := make(Checklist, len(cf.Checklist))
+	for i, item := range cf.Checklist {
+		if item.Timeout == 0 {
+			item.Timeout = timeout
+		}
+		items[i] = item
+	}
+	return items
+}
+
+/**
+ * Loads the given checklist file, merging in all the files it includes. The
+ * `parents` are the files currently being loaded, used to detect cycles, and
+ * the files that were already `loaded` (ex. included by two different files)
+ * are skipped, returning nil.
+ */
+func loadChecklistFile(filename string, parents []string, loaded map[string]bool) (*ChecklistFile, error) {
+	absFilename, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not resolve %s: %s", filename, err.Error())
+	}
+	for i, parent := range parents {
+		if parent == absFilename {
+			return nil, fmt.Errorf("Include cycle: %s", strings.Join(append(parents[i:], absFilename), " -> "))
+		}
+	}
+	if loaded[absFilename] {
+		return nil, nil
+	}
+	loaded[absFilename] = true
+	parents = append(parents, absFilename)
+
 	content, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("Could not read %s: %s", filename, err.Error())
 	}
 
+	// Unknown fields are rejected, since a typo would otherwise silently
+	// disable the respective feature
 	var cf ChecklistFile
-	err = yaml.Unmarshal(content, &cf)
-	if err != nil {
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	dec.KnownFields(true)
+	err = dec.Decode(&cf)
+	if err != nil && err != io.EOF {
 		return nil, fmt.Errorf("Could not parse %s: %s", filename, err.Error())
 	}
 
 	cf.Filename = filename
+	for i := range cf.Checklist {
+		cf.Checklist[i].Filename = filename
+	}
+
+	// The libraries of included files are relative to the included file, like
+	// their includes
+	if len(parents) > 1 {
+		for i, lib := range cf.Libs {
+			if !filepath.IsAbs(lib) {
+				cf.Libs[i] = filepath.Join(filepath.Dir(filename), lib)
+			}
+		}
+	}
+
+	// Included files are resolved relative to the including file, and their
+	// items come before the items of the including file
+	var included Checklist
+	for _, inc := range cf.Include {
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(filepath.Dir(filename), inc)
+		}
+
+		incFile, err := loadChecklistFile(inc, parents, loaded)
+		if err != nil {
+			return nil, err
+		}
+		if incFile == nil {
+			continue
+		}
+
+		for _, item := range incFile.Checklist {
+			if item.Timeout == 0 {
+				item.Timeout = incFile.Timeout
+			}
+			included = append(included, item)
+		}
+		cf.mergeIncluded(incFile)
+	}
+	cf.Checklist = append(included, cf.Checklist...)
+
 	return &cf, nil
 }
+
+/**
+ * Merges the definitions of the included file, without overriding the
+ * definitions of this file
+ */
+func (cf *ChecklistFile) mergeIncluded(inc *ChecklistFile) {
+	if cf.Provider == "" {
+		cf.Provider = inc.Provider
+	}
+
+	cf.Libs = append(cf.Libs, inc.Libs...)
+	cf.RequireTools = append(cf.RequireTools, inc.RequireTools...)
+	cf.Secrets = append(cf.Secrets, inc.Secrets...)
+	cf.RunbookSteps = append(cf.RunbookSteps, inc.RunbookSteps...)
+	cf.Notify = append(cf.Notify, inc.Notify...)
+
+	for name, spec := range inc.Vars {
+		if cf.Vars == nil {
+			cf.Vars = make(map[string]VarSpec)
+		}
+		if _, ok := cf.Vars[name]; !ok {
+			cf.Vars[name] = spec
+		}
+	}
+	for name, tpl := range inc.Templates {
+		if cf.Templates == nil {
+			cf.Templates = make(map[string]ChecklistItem)
+		}
+		if _, ok := cf.Templates[name]; !ok {
+			cf.Templates[name] = tpl
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package util
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadChecklistIncludedLibs(t *testing.T) {
+	dir, remove := writeTestFiles(t, map[string]string{
+		"main.yaml":                 "include: [common/common.yaml]\nlibs: [main.sh]\n",
+		"common/common.yaml":        "include: [nested/nested.yaml]\nlibs: [common.sh, /abs/lib.sh]\n",
+		"common/nested/nested.yaml": "libs: [../../shared.sh]\n",
+	})
+	defer remove()
+
+	cf, err := LoadChecklist(filepath.Join(dir, "main.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The libs of the loaded file keep being relative to the working directory
+	expected := []string{
+		"main.sh",
+		filepath.Join(dir, "common", "common.sh"),
+		"/abs/lib.sh",
+		filepath.Join(dir, "shared.sh"),
+	}
+	if !reflect.DeepEqual(cf.Libs, expected) {
+		t.Errorf("Expecting %v, got %v", expected, cf.Libs)
+	}
+}
+
+func TestLoadChecklistDiamondInclude(t *testing.T) {
+	dir, remove := writeTestFiles(t, map[string]string{
+		"a.yaml": "include: [b.yaml, c.yaml]\nchecklist:\n  - {title: A, script: 'true', depends_on: [b, c]}\n",
+		"b.yaml": "include: [d.yaml]\nchecklist:\n  - {title: B, id: b, script: 'true', depends_on: [base]}\n",
+		"c.yaml": "include: [d.yaml]\nchecklist:\n  - {title: C, id: c, script: 'true', depends_on: [base]}\n",
+		"d.yaml": "checklist:\n  - {title: Base, id: base, script: 'true'}\n",
+	})
+	defer remove()
+
+	cf, err := LoadChecklist(filepath.Join(dir, "a.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, item := range cf.Checklist {
+		titles = append(titles, item.Title)
+	}
+	expected := []string{"Base", "B", "C", "A"}
+	if !reflect.DeepEqual(titles, expected) {
+		t.Errorf("Expecting the shared file to be loaded once, got %v", titles)
+	}
+}
+
+func TestLoadChecklistIncludeCycle(t *testing.T) {
+	dir, remove := writeTestFiles(t, map[string]string{
+		"a.yaml": "include: [b.yaml]\n",
+		"b.yaml": "include: [a.yaml]\n",
+	})
+	defer remove()
+
+	_, err := LoadChecklist(filepath.Join(dir, "a.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "Include cycle") {
+		t.Errorf("Expecting an include cycle error, got: %v", err)
+	}
+}
+
+func TestChecklistItemsWithTimeout(t *testing.T) {
+	dir, remove := writeTestFiles(t, map[string]string{
+		"default.yaml": `timeout: 1m
+checklist:
+  - title: Inherited
+    script: "true"
+  - title: Own
+    script: "true"
+    timeout: 5s
+`,
+		"none.yaml": `checklist:
+  - title: Inherited
+    script: "true"
+  - title: Own
+    script: "true"
+    timeout: 5s
+`,
+	})
+	defer remove()
+
+	for _, c := range []struct {
+		filename string
+		override time.Duration
+		expected []time.Duration
+	}{
+		{"default.yaml", 0, []time.Duration{time.Minute, 5 * time.Second}},
+		{"default.yaml", 10 * time.Second, []time.Duration{10 * time.Second, 5 * time.Second}},
+		{"none.yaml", 0, []time.Duration{0, 5 * time.Second}},
+		{"none.yaml", 10 * time.Second, []time.Duration{10 * time.Second, 5 * time.Second}},
+	} {
+		cf, err := LoadChecklist(filepath.Join(dir, c.filename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		items := cf.ItemsWithTimeout(c.override)
+		for i, expected := range c.expected {
+			if time.Duration(items[i].Timeout) != expected {
+				t.Errorf("Expecting item %d of %s to time out after %s with -timeout %s, got %s",
+					i, c.filename, expected, c.override, time.Duration(items[i].Timeout))
+			}
+		}
+		if cf.Checklist[0].Timeout != 0 {
+			t.Errorf("Expecting the items of the checklist to be left as they are")
+		}
+	}
+}
@@ -3,48 +3,82 @@ package util
 import (
 	"fmt"
 	"io/ioutil"
-	"os/exec"
-	"strings"
 )
 
 type Config struct {
-	Env         map[string]string
-	UserLib     string
-	UserTools   []string
-	UserTempDir string
+	Env     map[string]string
+	Vars    map[string]VarSpec
+	Secrets []string
+	Notify  []NotifySpec
+
+	// Asks the operator for the value of a required variable that is
+	// missing, offering the given default. If nil, missing variables are
+	// reported as errors.
+	PromptVar     func(name string, spec *VarSpec, def string) string
+	Providers     []Provider
+	ProviderLib   string
+	ProviderTools []string
+	UserLib       string
+	UserTools     []string
+	UserTempDir   string
 }
 
 func CreateConfig() (*Config, error) {
 	config := &Config{
 		Env:       make(map[string]string),
+		Vars:      make(map[string]VarSpec),
 		UserLib:   "",
 		UserTools: nil,
 	}
 
-	// Get the cluster URL
-	out, err := exec.Command("dcos", "config", "show", "core.dcos_url").Output()
-	if err != nil {
-		return nil, fmt.Errorf("Could not get cluster: %s", err.Error())
+	return config, nil
+}
+
+/**
+ * Enables the given provider, collecting its environment, tools and library.
+ * Enabling the same provider more than once has no effect.
+ */
+func (c *Config) AddProvider(p Provider) error {
+	for _, existing := range c.Providers {
+		if existing.Name() == p.Name() {
+			return nil
+		}
 	}
-	config.Env["DCOS_URL"] = strings.Trim(string(out), "\r\n\t ")
 
-	// Get the ACS token
-	out, err = exec.Command("dcos", "config", "show", "core.dcos_acs_token").Output()
+	env, err := p.Env()
 	if err != nil {
-		return nil, fmt.Errorf("Could not get cluster: %s", err.Error())
+		return fmt.Errorf("Could not configure %s provider: %s", p.Name(), err.Error())
+	}
+	for name, value := range env {
+		c.Env[name] = value
+	}
+	for _, name := range p.Secrets() {
+		AddSecret(env[name])
 	}
-	config.Env["DCOS_ACS_TOKEN"] = strings.Trim(string(out), "\r\n\t ")
 
-	return config, nil
+	c.ProviderLib = fmt.Sprintf("%s\n%s", c.ProviderLib, p.BashLibrary())
+	c.ProviderTools = append(c.ProviderTools, p.RequiredTools()...)
+	c.Providers = append(c.Providers, p)
+	return nil
 }
 
 func (c *Config) AddChecklistFile(f *ChecklistFile) error {
-	// Collect environment variables
-	if f.Env != nil {
-		for name, value := range f.Env {
-			c.Env[name] = value
-		}
+	// Enable the platform provider
+	provider, err := CreateProvider(f.Provider)
+	if err != nil {
+		return fmt.Errorf("Could not use %s: %s", f.Filename, err.Error())
+	}
+	err = c.AddProvider(provider)
+	if err != nil {
+		return err
+	}
+
+	// Collect the variables, resolved later with ResolveVars
+	for name, spec := range f.Vars {
+		c.Vars[name] = spec
 	}
+	c.Secrets = append(c.Secrets, f.Secrets...)
+	c.Notify = append(c.Notify, f.Notify...)
 
 	// Pre-load library scripts
 	for _, lib := range f.Libs {
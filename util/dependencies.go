@@ -0,0 +1,150 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+/**
+ * The dependencies between the items of a checklist, resolved to item indices
+ */
+type DependencyGraph struct {
+	deps [][]int
+}
+
+/**
+ * Resolves the `depends_on` ids of the given items to their indices. The
+ * items are expected to come after the items they depend on (see
+ * SortChecklist), so every id is resolved to the closest preceding item with
+ * that id.
+ */
+func CreateDependencyGraph(items []ChecklistItem) *DependencyGraph {
+	index := make(map[string]int)
+	g := &DependencyGraph{deps: make([][]int, len(items))}
+	for i, item := range items {
+		for _, id := range item.DependsOn {
+			if dep, ok := index[id]; ok {
+				g.deps[i] = append(g.deps[i], dep)
+			}
+		}
+		if item.ID != "" {
+			index[item.ID] = i
+		}
+	}
+	return g
+}
+
+/**
+ * Returns the indices of the items the given item depends on
+ */
+func (g *DependencyGraph) Dependencies(idx int) []int {
+	return g.deps[idx]
+}
+
+/**
+ * Returns the index of the first dependency of the given item whose outcome
+ * blocks it from running, or -1 if the item is free to run
+ */
+func (g *DependencyGraph) BlockedBy(idx int, outcomes []Outcome) int {
+	for _, dep := range g.deps[idx] {
+		switch outcomes[dep] {
+		case OutcomeFail, OutcomeTimeout, OutcomeBlocked, OutcomeAborted:
+			return dep
+		}
+	}
+	return -1
+}
+
+/**
+ * Validates the item dependencies and returns the items in an order where
+ * every item comes after the items it depends on, otherwise preserving the
+ * original order.
+ */
+func SortChecklist(items Checklist) (Checklist, error) {
+	index := make(map[string]int)
+	for i, item := range items {
+		if item.ID == "" {
+			continue
+		}
+		if prev, ok := index[item.ID]; ok {
+			return nil, fmt.Errorf("Items '%s' and '%s' have the same id '%s'", items[prev].Title, item.Title, item.ID)
+		}
+		index[item.ID] = i
+	}
+
+	deps := make([][]int, len(items))
+	for i, item := range items {
+		for _, id := range item.DependsOn {
+			dep, ok := index[id]
+			if !ok {
+				return nil, fmt.Errorf("Item '%s' depends on unknown id '%s'", item.Title, id)
+			}
+			deps[i] = append(deps[i], dep)
+		}
+	}
+
+	placed := make([]bool, len(items))
+	var sorted Checklist
+	for len(sorted) < len(items) {
+		found := false
+		for i := range items {
+			if placed[i] {
+				continue
+			}
+
+			ready := true
+			for _, dep := range deps[i] {
+				if !placed[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				placed[i] = true
+				sorted = append(sorted, items[i])
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("Dependency cycle: %s", describeCycle(items, deps, placed))
+		}
+	}
+
+	return sorted, nil
+}
+
+/**
+ * Locates a cycle among the items not placed yet and describes it
+ */
+func describeCycle(items Checklist, deps [][]int, placed []bool) string {
+	// Every unplaced item has at least one unplaced dependency, so walking
+	// through them is guaranteed to revisit an item
+	visited := make(map[int]int)
+	var path []int
+	i := 0
+	for placed[i] {
+		i++
+	}
+	for {
+		if at, ok := visited[i]; ok {
+			path = append(path[at:], i)
+			break
+		}
+		visited[i] = len(path)
+		path = append(path, i)
+		for _, dep := range deps[i] {
+			if !placed[dep] {
+				i = dep
+				break
+			}
+		}
+	}
+
+	var names []string
+	for _, idx := range path {
+		names = append(names, items[idx].ID)
+	}
+	return strings.Join(names, " -> ")
+}
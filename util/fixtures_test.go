@@ -0,0 +1,52 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/**
+ * Creates a temporary directory with the given files, keyed by their path
+ * relative to the directory, returning the directory and the function
+ * removing it
+ */
+func writeTestFiles(t *testing.T, files map[string]string) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "preflighter-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remove := func() { os.RemoveAll(dir) }
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(path, []byte(content), 0644)
+		}
+		if err != nil {
+			remove()
+			t.Fatal(err)
+		}
+	}
+	return dir, remove
+}
+
+/**
+ * Creates a runner with an empty configuration, returning it with the
+ * function cleaning it up
+ */
+func createTestRunner(t *testing.T) (*Runner, func()) {
+	t.Helper()
+	config, err := CreateConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner, err := CreateRunner(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runner, runner.Cleanup
+}
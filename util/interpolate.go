@@ -0,0 +1,134 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A `{{ name | filters }}` placeholder, optionally escaped as `\{{ name }}`.
+// Anything else between braces (ex. `{{.State.Running}}` in a Go template)
+// is not a placeholder.
+var rxPlaceholder = regexp.MustCompile(`(\\?){{\s*([A-Za-z_][\w-]*)\s*((?:\|[^{}]*)?)}}`)
+
+// A filter of an expression, with its optional argument
+var rxFilter = regexp.MustCompile(`^([a-z]+)(?:\s+(.*))?$`)
+
+/**
+ * The filters that can be applied to the value of a placeholder, with their
+ * optional argument. The value is nil if the variable is undefined.
+ */
+var interpolateFilters = map[string]func(value *string, arg string) (*string, error){
+	"default": func(value *string, arg string) (*string, error) {
+		if value == nil || *value == "" {
+			return &arg, nil
+		}
+		return value, nil
+	},
+	"upper": stringFilter(strings.ToUpper),
+	"lower": stringFilter(strings.ToLower),
+	"trim":  stringFilter(strings.TrimSpace),
+	"quote": stringFilter(func(s string) string {
+		return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+	}),
+}
+
+func stringFilter(fn func(string) string) func(*string, string) (*string, error) {
+	return func(value *string, arg string) (*string, error) {
+		if value == nil {
+			return nil, nil
+		}
+		result := fn(*value)
+		return &result, nil
+	}
+}
+
+/**
+ * Parses the argument of a filter, either a quoted string or a bare word
+ */
+func filterArgument(arg string) (string, error) {
+	arg = strings.TrimSpace(arg)
+	if strings.HasPrefix(arg, `"`) {
+		value, err := strconv.Unquote(arg)
+		if err != nil {
+			return "", fmt.Errorf("Invalid string %s", arg)
+		}
+		return value, nil
+	}
+	if strings.HasPrefix(arg, "'") && strings.HasSuffix(arg, "'") && len(arg) >= 2 {
+		return arg[1 : len(arg)-1], nil
+	}
+	return arg, nil
+}
+
+/**
+ * Evaluates a single placeholder: a variable name followed by any number of
+ * `| filter [argument]`
+ */
+func evalPlaceholder(name string, filters string, vars map[string]string) (string, error) {
+	expr := strings.TrimSpace(name + " " + filters)
+	var value *string
+	if v, ok := vars[name]; ok {
+		value = &v
+	}
+
+	parts := strings.Split(filters, "|")
+	for _, part := range parts[1:] {
+		m := rxFilter.FindStringSubmatch(strings.TrimSpace(part))
+		if m == nil {
+			return "", fmt.Errorf("Invalid filter '%s' in {{%s}}", strings.TrimSpace(part), expr)
+		}
+		filter, ok := interpolateFilters[m[1]]
+		if !ok {
+			return "", fmt.Errorf("Unknown filter '%s' in {{%s}}", m[1], expr)
+		}
+		arg, err := filterArgument(m[2])
+		if err != nil {
+			return "", fmt.Errorf("%s in {{%s}}", err.Error(), expr)
+		}
+		value, err = filter(value, arg)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if value == nil {
+		return "", fmt.Errorf("Undefined variable '%s'", name)
+	}
+	return *value, nil
+}
+
+/**
+ * Replaces the `{{ name }}` placeholders in the given text with the values of
+ * the variables, applying the filters given as `{{ name | filter [arg] }}`:
+ *
+ * - default <value> : The value to use if the variable is undefined or empty
+ * - upper, lower    : Changes the case of the value
+ * - trim            : Removes the leading and trailing whitespace
+ * - quote           : Quotes the value for use in a shell script
+ *
+ * Only variable names are replaced, leaving any other text between braces as
+ * it is, and `\{{name}}` is replaced with a literal `{{name}}`. All the
+ * undefined variables and invalid placeholders are reported.
+ */
+func Interpolate(text string, vars map[string]string) (string, error) {
+	var problems []string
+	result := rxPlaceholder.ReplaceAllStringFunc(text, func(placeholder string) string {
+		m := rxPlaceholder.FindStringSubmatch(placeholder)
+		if m[1] != "" {
+			return placeholder[1:]
+		}
+		value, err := evalPlaceholder(m[2], m[3], vars)
+		if err != nil {
+			problems = append(problems, err.Error())
+			return placeholder
+		}
+		return value
+	})
+
+	if len(problems) > 0 {
+		return "", fmt.Errorf("%s", strings.Join(problems, ", "))
+	}
+	return result, nil
+}
@@ -0,0 +1,78 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	vars := map[string]string{
+		"name":    "Frontend",
+		"padded":  "  x  ",
+		"empty":   "",
+		"quoted":  "it's",
+		"dash-ed": "ok",
+	}
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"{{name}}", "Frontend"},
+		{"a {{ name }} b", "a Frontend b"},
+		{"{{name | upper}}", "FRONTEND"},
+		{"{{name|lower}}", "frontend"},
+		{"[{{padded | trim}}]", "[x]"},
+		{"{{quoted | quote}}", `'it'\''s'`},
+		{"{{missing | default 3}}", "3"},
+		{`{{missing | default "a b"}}`, "a b"},
+		{"{{missing | default 'a b'}}", "a b"},
+		{"{{empty | default x}}", "x"},
+		{"{{name | default x}}", "Frontend"},
+		{"{{missing | default Mixed | lower}}", "mixed"},
+		{"{{name | lower | upper}}", "FRONTEND"},
+		{"{{dash-ed}}", "ok"},
+
+		// Braces that are not placeholders are left alone
+		{"docker inspect --format '{{.State.Running}}'", "docker inspect --format '{{.State.Running}}'"},
+		{"jq '{a: .b}' {{name}}", "jq '{a: .b}' Frontend"},
+		{"{{ $x := 1 }}{{ if .x }}", "{{ $x := 1 }}{{ if .x }}"},
+		{`\{{end}} {{name}}`, "{{end}} Frontend"},
+	}
+
+	for _, test := range tests {
+		result, err := Interpolate(test.text, vars)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.text, err.Error())
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("%s: expecting %q, got %q", test.text, test.expected, result)
+		}
+	}
+}
+
+func TestInterpolateErrors(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"{{missing}}", "Undefined variable 'missing'"},
+		{"{{missing | upper}}", "Undefined variable 'missing'"},
+		{"{{name | bogus}}", "Unknown filter 'bogus' in {{name | bogus}}"},
+		{"{{name | Upper}}", "Invalid filter 'Upper'"},
+		{`{{name | default "x}}`, "Invalid string"},
+	}
+
+	for _, test := range tests {
+		_, err := Interpolate(test.text, map[string]string{"name": "x"})
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s: expecting an error with %q, got: %v", test.text, test.expected, err)
+		}
+	}
+
+	// All the problems are reported together
+	_, err := Interpolate("{{a}} {{b}}", nil)
+	if err == nil || !strings.Contains(err.Error(), "'a'") || !strings.Contains(err.Error(), "'b'") {
+		t.Errorf("Expecting both variables to be reported, got: %v", err)
+	}
+}
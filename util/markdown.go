@@ -0,0 +1,187 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lithammer/dedent"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/text"
+)
+
+// The `{!<id>}` marker of a runbook checklist item
+var rxMdMarker = regexp.MustCompile(`{!([^}]+)}`)
+
+// The languages of the fenced code blocks that are executed
+var mdScriptLanguages = map[string]bool{
+	"sh":    true,
+	"bash":  true,
+	"shell": true,
+}
+
+/**
+ * The contents of the instructions under a `{!<id>}` marker
+ */
+type mdSection struct {
+	id      string
+	scripts []string
+	expect  string
+}
+
+/**
+ * Collects the sections of a markdown document, and the problems found along
+ * the way
+ */
+type mdWalker struct {
+	source   []byte
+	sections []*mdSection
+	ids      map[string]bool
+	warnings []string
+}
+
+/**
+ * Collects the sections of the instructions under every `{!<id>}` marker, in
+ * the order they appear, using a CommonMark parser. A section spans the list
+ * item (or the paragraph or heading) with the marker and everything nested in
+ * it, as well as the blocks that follow it: up to the next list item for a
+ * list item, or up to the next marker for a paragraph or heading. Nested
+ * sections are collected on their own, and block quotes are transparent.
+ *
+ * The `sh`, `bash` and `shell` fenced code blocks of a section are its
+ * scripts, and the first `expect` fenced code block is its expectation. Any
+ * other block (indented code, HTML, ...) is ignored. Returns the sections and
+ * warnings about the parts of the document that were ignored.
+ */
+func markdownSections(markdown string) ([]*mdSection, []string) {
+	w := &mdWalker{
+		source: []byte(markdown),
+		ids:    make(map[string]bool),
+	}
+	doc := goldmark.DefaultParser().Parse(text.NewReader(w.source))
+	w.walk(mdChildren(doc), nil)
+	return w.sections, w.warnings
+}
+
+/**
+ * Returns the children of the node, replacing the lists with their items so
+ * that a section started by an item can extend past the end of the list
+ */
+func mdChildren(node ast.Node) []ast.Node {
+	var children []ast.Node
+	for c := node.FirstChild(); c != nil; c = c.NextSibling() {
+		if c.Kind() == ast.KindList {
+			children = append(children, mdChildren(c)...)
+		} else {
+			children = append(children, c)
+		}
+	}
+	return children
+}
+
+/**
+ * Returns the raw text of a block, and the line it starts at
+ */
+func (w *mdWalker) blockText(node ast.Node) (string, int) {
+	var buf bytes.Buffer
+	lines := node.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		segment := lines.At(i)
+		buf.Write(segment.Value(w.source))
+	}
+	line := 0
+	if lines.Len() > 0 {
+		line = bytes.Count(w.source[:lines.At(0).Start], []byte("\n")) + 1
+	}
+	return buf.String(), line
+}
+
+/**
+ * Starts a new section if the given block has a marker
+ */
+func (w *mdWalker) marker(node ast.Node) *mdSection {
+	switch node.Kind() {
+	case ast.KindParagraph, ast.KindTextBlock, ast.KindHeading:
+	default:
+		return nil
+	}
+
+	content, line := w.blockText(node)
+	markers := rxMdMarker.FindAllStringSubmatch(content, -1)
+	if len(markers) == 0 {
+		return nil
+	}
+	id := strings.TrimSpace(markers[0][1])
+	if len(markers) > 1 {
+		w.warnings = append(w.warnings, fmt.Sprintf("Line %d has more than one item marker, only {!%s} is used", line, id))
+	}
+
+	section := &mdSection{id: id}
+	if w.ids[id] {
+		w.warnings = append(w.warnings, fmt.Sprintf("Item %s is marked again at line %d, the second marker is ignored", id, line))
+	} else {
+		w.ids[id] = true
+		w.sections = append(w.sections, section)
+	}
+	return section
+}
+
+func (w *mdWalker) walk(nodes []ast.Node, inherited *mdSection) {
+	current := inherited
+	itemOwned := false
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *ast.FencedCodeBlock:
+			lang := strings.ToLower(string(n.Language(w.source)))
+			content, _ := w.blockText(n)
+			switch {
+			case current == nil:
+				if mdScriptLanguages[lang] {
+					line := bytes.Count(w.source[:n.Info.Segment.Start], []byte("\n")) + 1
+					w.warnings = append(w.warnings, fmt.Sprintf("The %s code block at line %d is not part of any item", lang, line))
+				}
+			case mdScriptLanguages[lang]:
+				current.scripts = append(current.scripts, strings.TrimRight(dedent.Dedent(content), "\n"))
+			case lang == "expect" && current.expect == "":
+				current.expect = strings.TrimSpace(content)
+			}
+
+		case *ast.ListItem:
+			children := mdChildren(n)
+			var section *mdSection
+			if len(children) > 0 {
+				section = w.marker(children[0])
+			}
+			if section != nil {
+				w.walk(children[1:], section)
+				current = section
+				itemOwned = true
+				continue
+			}
+
+			// An item without a marker ends the section of the previous item
+			if itemOwned {
+				current = inherited
+				itemOwned = false
+			}
+			w.walk(children, current)
+
+		case *ast.Blockquote:
+			w.walk(mdChildren(n), current)
+
+		case *ast.HTMLBlock:
+			content, line := w.blockText(n)
+			if rxMdMarker.MatchString(content) {
+				w.warnings = append(w.warnings, fmt.Sprintf("The item marker in the HTML block at line %d is ignored", line))
+			}
+
+		default:
+			if section := w.marker(node); section != nil {
+				current = section
+				itemOwned = false
+			}
+		}
+	}
+}
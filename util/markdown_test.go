@@ -0,0 +1,177 @@
+package util
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarkdownSections(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown []string
+		expected []mdSection
+	}{
+		{
+			"list items",
+			[]string{
+				"* {!a} First",
+				"  ```sh",
+				"  echo a",
+				"  ```",
+				"* {!b} Second",
+				"  ```bash",
+				"  echo b",
+				"  ```",
+				"  ```expect",
+				"  ^b$",
+				"  ```",
+			},
+			[]mdSection{
+				{id: "a", scripts: []string{"echo a"}},
+				{id: "b", scripts: []string{"echo b"}, expect: "^b$"},
+			},
+		},
+		{
+			"nested lists and multiple blocks",
+			[]string{
+				"1. {!outer} Outer",
+				"   ```shell",
+				"   echo 1",
+				"   ```",
+				"   - {!inner} Inner",
+				"     ```sh",
+				"     echo inner",
+				"     ```",
+				"   - Not an item",
+				"     ```sh",
+				"     echo 2",
+				"     ```",
+			},
+			[]mdSection{
+				{id: "outer", scripts: []string{"echo 1", "echo 2"}},
+				{id: "inner", scripts: []string{"echo inner"}},
+			},
+		},
+		{
+			"blocks after the list item",
+			[]string{
+				"* {!a} Item",
+				"",
+				"```sh",
+				"echo after",
+				"```",
+			},
+			[]mdSection{
+				{id: "a", scripts: []string{"echo after"}},
+			},
+		},
+		{
+			"headings and paragraphs",
+			[]string{
+				"## Check the cluster {!cluster}",
+				"",
+				"* Show the cluster:",
+				"  ~~~sh",
+				"  dcos cluster list",
+				"  ~~~",
+				"",
+				"Then {!nodes} check the nodes",
+				"",
+				"    echo indented code is ignored",
+				"",
+				"```sh",
+				"dcos node",
+				"```",
+			},
+			[]mdSection{
+				{id: "cluster", scripts: []string{"dcos cluster list"}},
+				{id: "nodes", scripts: []string{"dcos node"}},
+			},
+		},
+		{
+			"block quotes and other languages",
+			[]string{
+				"* {!quoted} Item",
+				"  > Note:",
+				"  > ```sh",
+				"  > echo quoted",
+				"  > ```",
+				"  ```json",
+				"  {}",
+				"  ```",
+				"  ````sh",
+				"  echo ```",
+				"  ````",
+			},
+			[]mdSection{
+				{id: "quoted", scripts: []string{"echo quoted", "echo ```"}},
+			},
+		},
+		{
+			"scripts are dedented",
+			[]string{
+				"* {!a} Item",
+				"  ```sh",
+				"    if true; then",
+				"      echo a",
+				"    fi",
+				"  ```",
+			},
+			[]mdSection{
+				{id: "a", scripts: []string{"if true; then\n  echo a\nfi"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		sections, warnings := markdownSections(strings.Join(test.markdown, "\n"))
+		if len(warnings) > 0 {
+			t.Errorf("%s: unexpected warnings: %v", test.name, warnings)
+		}
+		var got []mdSection
+		for _, section := range sections {
+			got = append(got, *section)
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: expecting %+v, got %+v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestMarkdownSectionsWarnings(t *testing.T) {
+	markdown := strings.Join([]string{
+		"```sh",
+		"echo orphan",
+		"```",
+		"",
+		"<div>{!html}</div>",
+		"",
+		"* {!a} {!b} Two markers",
+		"* {!a} Again",
+		"  ```sh",
+		"  echo again",
+		"  ```",
+		"* An item without a marker ends the section",
+		"",
+		"```sh",
+		"echo orphan",
+		"```",
+	}, "\n")
+
+	sections, warnings := markdownSections(markdown)
+	if len(sections) != 1 || sections[0].id != "a" || len(sections[0].scripts) != 0 {
+		t.Errorf("Expecting only the first section of a, got %+v", sections)
+	}
+
+	expected := []string{
+		"The sh code block at line 1 is not part of any item",
+		"The item marker in the HTML block at line 5 is ignored",
+		"Line 7 has more than one item marker, only {!a} is used",
+		"Item a is marked again at line 8, the second marker is ignored",
+		"The sh code block at line 14 is not part of any item",
+	}
+	if !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("Expecting the warnings %q, got %q", expected, warnings)
+	}
+}
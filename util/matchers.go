@@ -0,0 +1,608 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/**
+ * An assertion on a value extracted from the JSON output of a script
+ */
+type JSONAssertion struct {
+	Path     string   `yaml:"path"`
+	Exists   *bool    `yaml:"exists"`
+	Equals   *string  `yaml:"equals"`
+	OneOf    []string `yaml:"one_of"`
+	Match    string   `yaml:"match"`
+	NotMatch string   `yaml:"not_match"`
+	Number   string   `yaml:"number"`
+	Version  string   `yaml:"version"`
+}
+
+// A minus sign only counts at the start or after a non-word character, so
+// that `node-1` is the number 1
+var rxNumber = regexp.MustCompile(`(?:(?:^|[^\w])(-))?(\d+(?:\.\d+)?)`)
+
+// A version has at least a major and minor number, while a single number is
+// only used if there is no such version (ex. in `^2`). Neither can be part of
+// a longer number, so that `build 2024 v1.2.3` is version 1.2.3.
+var rxVersion = regexp.MustCompile(`(?:^|[^\d.])(v?(\d+)\.(\d+)(?:\.(\d+))?(?:-([0-9A-Za-z.-]+))?)`)
+var rxVersionMajor = regexp.MustCompile(`(?:^|[^\d.])(v?(\d+))(?:[^\d.]|$)`)
+
+/**
+ * A matcher checks a value and returns a description of the mismatch, or an
+ * empty string if the value matches
+ */
+type matcher func(value string) string
+
+/**
+ * Creates a matcher that checks if the given regex matches (or does not match
+ * if `negate` is true)
+ */
+func regexMatcher(expr string, negate bool) (matcher, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(value string) string {
+		if re.MatchString(value) == negate {
+			if negate {
+				return fmt.Sprintf("\"%s\" matches /%s/", value, expr)
+			}
+			return fmt.Sprintf("\"%s\" does not match /%s/", value, expr)
+		}
+		return ""
+	}, nil
+}
+
+/**
+ * Creates a matcher that checks if the value is one of the given options
+ */
+func oneOfMatcher(options []string) matcher {
+	return func(value string) string {
+		for _, opt := range options {
+			if value == opt {
+				return ""
+			}
+		}
+		if len(options) == 1 {
+			return fmt.Sprintf("\"%s\" is not \"%s\"", value, options[0])
+		}
+		return fmt.Sprintf("\"%s\" is not one of %q", value, options)
+	}
+}
+
+/**
+ * Compares two values, according to the given operator
+ */
+func compareWith(op string, cmp int) bool {
+	switch op {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	case "!=":
+		return cmp != 0
+	}
+	return cmp == 0
+}
+
+/**
+ * Splits a constraint term (ex. ">= 20") to the operator and the operand
+ */
+func splitConstraint(term string) (string, string) {
+	for _, op := range []string{">=", "<=", "==", "!=", ">", "<", "="} {
+		if strings.HasPrefix(term, op) {
+			return op, strings.TrimSpace(term[len(op):])
+		}
+	}
+	return "==", term
+}
+
+func compareFloats(a float64, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+/**
+ * Creates a matcher that extracts the first number of the value and checks it
+ * against all the comma-separated constraints in the expression, for example
+ * ">= 20", ">= 1, < 10", "!= 0" or the inclusive range "10..20".
+ */
+func numberMatcher(expr string) (matcher, error) {
+	var checks []func(float64) bool
+	for _, term := range strings.Split(expr, ",") {
+		term = strings.TrimSpace(term)
+
+		if parts := strings.SplitN(term, "..", 2); len(parts) == 2 {
+			min, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid range '%s'", term)
+			}
+			max, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid range '%s'", term)
+			}
+			checks = append(checks, func(v float64) bool { return v >= min && v <= max })
+			continue
+		}
+
+		op, operand := splitConstraint(term)
+		num, err := strconv.ParseFloat(operand, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid number constraint '%s'", term)
+		}
+		checks = append(checks, func(v float64) bool { return compareWith(op, compareFloats(v, num)) })
+	}
+
+	return func(value string) string {
+		m := rxNumber.FindStringSubmatch(value)
+		if m == nil {
+			return fmt.Sprintf("\"%s\" is not a number", value)
+		}
+		found := m[1] + m[2]
+		num, _ := strconv.ParseFloat(found, 64)
+		for _, check := range checks {
+			if !check(num) {
+				return fmt.Sprintf("%s does not satisfy '%s'", found, expr)
+			}
+		}
+		return ""
+	}, nil
+}
+
+/**
+ * A parsed semantic version
+ */
+type version struct {
+	parts      [3]int
+	prerelease string
+
+	// The number of parts that were given, and the text of the version
+	given int
+	text  string
+}
+
+/**
+ * Extracts the first version in the text
+ */
+func parseVersion(text string) (*version, bool) {
+	m := rxVersion.FindStringSubmatch(text)
+	if m == nil {
+		m = rxVersionMajor.FindStringSubmatch(text)
+		if m == nil {
+			return nil, false
+		}
+		m = []string{m[0], m[1], m[2], "", "", ""}
+	}
+
+	v := &version{prerelease: m[5], text: m[1]}
+	for i, part := range m[2:5] {
+		if part != "" {
+			v.parts[i], _ = strconv.Atoi(part)
+			v.given++
+		}
+	}
+	return v, true
+}
+
+/**
+ * Returns the lowest version above the range of a `~` or `^` constraint. The
+ * tilde allows patch-level changes (or minor-level ones if only the major
+ * version is given), and the caret allows the changes that don't modify the
+ * left-most non-zero part: `^1.2.3` is below 2.0.0, `^0.2.3` below 0.3.0 and
+ * `^0.0.3` below 0.0.4.
+ */
+func (v *version) rangeEnd(op byte) *version {
+	end := &version{}
+	switch {
+	case op == '~' && v.given > 1:
+		end.parts = [3]int{v.parts[0], v.parts[1] + 1, 0}
+	case v.parts[0] != 0 || v.given == 1 || op == '~':
+		end.parts = [3]int{v.parts[0] + 1, 0, 0}
+	case v.parts[1] != 0 || v.given == 2:
+		end.parts = [3]int{0, v.parts[1] + 1, 0}
+	default:
+		end.parts = [3]int{0, 0, v.parts[2] + 1}
+	}
+	return end
+}
+
+/**
+ * Compares two versions, following the semver precedence rules
+ */
+func (v *version) compare(o *version) int {
+	for i := range v.parts {
+		if v.parts[i] != o.parts[i] {
+			return v.parts[i] - o.parts[i]
+		}
+	}
+
+	// A pre-release version has lower precedence than the normal version
+	if v.prerelease == o.prerelease {
+		return 0
+	} else if v.prerelease == "" {
+		return 1
+	} else if o.prerelease == "" {
+		return -1
+	}
+
+	a := strings.Split(v.prerelease, ".")
+	b := strings.Split(o.prerelease, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		na, errA := strconv.Atoi(a[i])
+		nb, errB := strconv.Atoi(b[i])
+		if errA == nil && errB == nil {
+			if na != nb {
+				return na - nb
+			}
+		} else if a[i] != b[i] {
+			return strings.Compare(a[i], b[i])
+		}
+	}
+	return len(a) - len(b)
+}
+
+/**
+ * Creates a matcher that extracts the first version of the value and checks
+ * it against the given semver constraints. Constraints separated with commas
+ * must all be satisfied, while alternatives can be given with `||`. Besides
+ * the comparison operators, `~1.2` allows patch-level changes and `^1.2`
+ * allows minor-level changes (see rangeEnd).
+ */
+func versionMatcher(expr string) (matcher, error) {
+	var alternatives [][]func(*version) bool
+	for _, alt := range strings.Split(expr, "||") {
+		var checks []func(*version) bool
+		for _, term := range strings.Split(alt, ",") {
+			term = strings.TrimSpace(term)
+
+			if strings.HasPrefix(term, "~") || strings.HasPrefix(term, "^") {
+				min, ok := parseVersion(term[1:])
+				if !ok {
+					return nil, fmt.Errorf("Invalid version constraint '%s'", term)
+				}
+				max := min.rangeEnd(term[0])
+				checks = append(checks, func(v *version) bool {
+					return v.compare(min) >= 0 && v.compare(max) < 0
+				})
+				continue
+			}
+
+			op, operand := splitConstraint(term)
+			ref, ok := parseVersion(operand)
+			if !ok {
+				return nil, fmt.Errorf("Invalid version constraint '%s'", term)
+			}
+			checks = append(checks, func(v *version) bool { return compareWith(op, v.compare(ref)) })
+		}
+		alternatives = append(alternatives, checks)
+	}
+
+	return func(value string) string {
+		v, ok := parseVersion(value)
+		if !ok {
+			return fmt.Sprintf("\"%s\" is not a version", value)
+		}
+
+	NextAlternative:
+		for _, checks := range alternatives {
+			for _, check := range checks {
+				if !check(v) {
+					continue NextAlternative
+				}
+			}
+			return ""
+		}
+		return fmt.Sprintf("%s does not satisfy '%s'", v.text, expr)
+	}, nil
+}
+
+/**
+ * A single step of a JSON path, either an object key or an array index
+ */
+type jsonPathSegment struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+/**
+ * A parsed jq-style path (ex. `.nodes[0].health`, `.["key"]` or
+ * `.nodes | length`)
+ */
+type jsonPath struct {
+	segments  []jsonPathSegment
+	functions []string
+}
+
+func parseJSONPath(path string) (*jsonPath, error) {
+	p := &jsonPath{}
+	pipes := strings.Split(path, "|")
+	expr := strings.TrimSpace(pipes[0])
+	if !strings.HasPrefix(expr, ".") {
+		return nil, fmt.Errorf("Path '%s' must start with '.'", path)
+	}
+
+	for len(expr) > 0 {
+		switch {
+		case expr == ".":
+			expr = ""
+
+		case strings.HasPrefix(expr, ".["), strings.HasPrefix(expr, "["):
+			expr = strings.TrimPrefix(expr, ".")
+			end := strings.Index(expr, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("Missing ']' in path '%s'", path)
+			}
+			key := strings.TrimSpace(expr[1:end])
+			expr = expr[end+1:]
+
+			if idx, err := strconv.Atoi(key); err == nil {
+				p.segments = append(p.segments, jsonPathSegment{index: idx, isIndex: true})
+			} else {
+				p.segments = append(p.segments, jsonPathSegment{key: strings.Trim(key, `"`)})
+			}
+
+		case strings.HasPrefix(expr, "."):
+			expr = expr[1:]
+			end := strings.IndexAny(expr, ".[")
+			if end < 0 {
+				end = len(expr)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("Empty key in path '%s'", path)
+			}
+			p.segments = append(p.segments, jsonPathSegment{key: expr[:end]})
+			expr = expr[end:]
+
+		default:
+			return nil, fmt.Errorf("Invalid path '%s'", path)
+		}
+	}
+
+	for _, fn := range pipes[1:] {
+		fn = strings.TrimSpace(fn)
+		if fn != "length" {
+			return nil, fmt.Errorf("Unsupported function '%s' in path '%s'", fn, path)
+		}
+		p.functions = append(p.functions, fn)
+	}
+
+	return p, nil
+}
+
+/**
+ * Evaluates the path against the decoded JSON document, returning false if
+ * the path does not exist in the document
+ */
+func (p *jsonPath) eval(doc interface{}) (interface{}, bool, error) {
+	value := doc
+	for _, seg := range p.segments {
+		if seg.isIndex {
+			arr, ok := value.([]interface{})
+			idx := seg.index
+			if idx < 0 {
+				idx += len(arr)
+			}
+			if !ok || idx < 0 || idx >= len(arr) {
+				return nil, false, nil
+			}
+			value = arr[idx]
+		} else {
+			obj, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, false, nil
+			}
+			value, ok = obj[seg.key]
+			if !ok {
+				return nil, false, nil
+			}
+		}
+	}
+
+	for range p.functions {
+		switch v := value.(type) {
+		case []interface{}:
+			value = json.Number(strconv.Itoa(len(v)))
+		case map[string]interface{}:
+			value = json.Number(strconv.Itoa(len(v)))
+		case string:
+			value = json.Number(strconv.Itoa(len(v)))
+		default:
+			return nil, false, fmt.Errorf("Cannot compute the length of %s", jsonValueString(v))
+		}
+	}
+
+	return value, true, nil
+}
+
+/**
+ * Converts a decoded JSON value to the string used by the matchers
+ */
+func jsonValueString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case nil:
+		return "null"
+	}
+	content, _ := json.Marshal(value)
+	return string(content)
+}
+
+/**
+ * Creates the matchers of the assertion, applied to the extracted value
+ */
+func (a *JSONAssertion) matchers() ([]matcher, error) {
+	var matchers []matcher
+	if a.Equals != nil {
+		matchers = append(matchers, oneOfMatcher([]string{*a.Equals}))
+	}
+	if len(a.OneOf) > 0 {
+		matchers = append(matchers, oneOfMatcher(a.OneOf))
+	}
+	if a.Match != "" {
+		m, err := regexMatcher(a.Match, false)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	if a.NotMatch != "" {
+		m, err := regexMatcher(a.NotMatch, true)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	if a.Number != "" {
+		m, err := numberMatcher(a.Number)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	if a.Version != "" {
+		m, err := versionMatcher(a.Version)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+/**
+ * Creates a matcher that decodes the value as JSON and checks all the given
+ * assertions
+ */
+func jsonMatcher(assertions []JSONAssertion) (matcher, error) {
+	type compiled struct {
+		assertion JSONAssertion
+		path      *jsonPath
+		matchers  []matcher
+	}
+
+	var all []compiled
+	for _, a := range assertions {
+		if a.Path == "" {
+			return nil, fmt.Errorf("Missing path in JSON assertion")
+		}
+		path, err := parseJSONPath(a.Path)
+		if err != nil {
+			return nil, err
+		}
+		m, err := a.matchers()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid assertion on '%s': %s", a.Path, err.Error())
+		}
+		all = append(all, compiled{a, path, m})
+	}
+
+	return func(value string) string {
+		var doc interface{}
+		dec := json.NewDecoder(bytes.NewReader([]byte(value)))
+		dec.UseNumber()
+		err := dec.Decode(&doc)
+		if err != nil {
+			return fmt.Sprintf("Output is not valid JSON: %s", err.Error())
+		}
+
+		var failures []string
+		for _, c := range all {
+			found, exists, err := c.path.eval(doc)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %s", c.assertion.Path, err.Error()))
+				continue
+			}
+			if c.assertion.Exists != nil && *c.assertion.Exists != exists {
+				if exists {
+					failures = append(failures, fmt.Sprintf("%s: exists", c.assertion.Path))
+				} else {
+					failures = append(failures, fmt.Sprintf("%s: does not exist", c.assertion.Path))
+				}
+				continue
+			}
+			if !exists {
+				if len(c.matchers) > 0 {
+					failures = append(failures, fmt.Sprintf("%s: does not exist", c.assertion.Path))
+				}
+				continue
+			}
+
+			str := jsonValueString(found)
+			for _, m := range c.matchers {
+				if reason := m(str); reason != "" {
+					failures = append(failures, fmt.Sprintf("%s: %s", c.assertion.Path, reason))
+				}
+			}
+		}
+
+		return strings.Join(failures, "\n")
+	}, nil
+}
+
+/**
+ * Compiles all the declarative expectations of the item. The field of the
+ * first invalid expectation is reported in the error.
+ */
+func itemMatchers(item *ChecklistItem) ([]matcher, error) {
+	var matchers []matcher
+
+	if item.ExpectMatch != "" {
+		m, err := regexMatcher(item.ExpectMatch, false)
+		if err != nil {
+			return nil, &ItemFieldError{"expect", fmt.Sprintf("Item '%s' has an invalid expect regex: %s", item.Title, err.Error())}
+		}
+		matchers = append(matchers, m)
+	}
+	if item.NotMatch != "" {
+		m, err := regexMatcher(item.NotMatch, true)
+		if err != nil {
+			return nil, &ItemFieldError{"not_match", fmt.Sprintf("Item '%s' has an invalid not_match regex: %s", item.Title, err.Error())}
+		}
+		matchers = append(matchers, m)
+	}
+	if item.ExpectNumber != "" {
+		m, err := numberMatcher(item.ExpectNumber)
+		if err != nil {
+			return nil, &ItemFieldError{"expect_number", fmt.Sprintf("Item '%s' has an invalid expect_number: %s", item.Title, err.Error())}
+		}
+		matchers = append(matchers, m)
+	}
+	if item.ExpectVersion != "" {
+		m, err := versionMatcher(item.ExpectVersion)
+		if err != nil {
+			return nil, &ItemFieldError{"expect_version", fmt.Sprintf("Item '%s' has an invalid expect_version: %s", item.Title, err.Error())}
+		}
+		matchers = append(matchers, m)
+	}
+	if len(item.ExpectOneOf) > 0 {
+		matchers = append(matchers, oneOfMatcher(item.ExpectOneOf))
+	}
+	if len(item.ExpectJSON) > 0 {
+		m, err := jsonMatcher(item.ExpectJSON)
+		if err != nil {
+			return nil, &ItemFieldError{"expect_json", fmt.Sprintf("Item '%s' has an invalid expect_json: %s", item.Title, err.Error())}
+		}
+		matchers = append(matchers, m)
+	}
+
+	return matchers, nil
+}
@@ -0,0 +1,130 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+/**
+ * Checks the values that match and don't match each expression of a matcher
+ */
+type matcherTest struct {
+	expr     string
+	matching []string
+	failing  []string
+}
+
+func runMatcherTests(t *testing.T, create func(string) (matcher, error), tests []matcherTest) {
+	t.Helper()
+	for _, test := range tests {
+		m, err := create(test.expr)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.expr, err.Error())
+			continue
+		}
+		for _, value := range test.matching {
+			if reason := m(value); reason != "" {
+				t.Errorf("%s: expecting %q to match, got: %s", test.expr, value, reason)
+			}
+		}
+		for _, value := range test.failing {
+			if m(value) == "" {
+				t.Errorf("%s: expecting %q not to match", test.expr, value)
+			}
+		}
+	}
+}
+
+func TestNumberMatcher(t *testing.T) {
+	runMatcherTests(t, numberMatcher, []matcherTest{
+		{">= 20", []string{"20", "21.5", "nodes: 30 of 40"}, []string{"19", "-20", "none"}},
+		{">= 1, < 10", []string{"1", "9.99"}, []string{"0", "10"}},
+		{"10..20", []string{"10", "15", "20"}, []string{"9", "20.1"}},
+		{"!= 0", []string{"1", "-1"}, []string{"0", "0.0"}},
+		{">= 0", []string{"node-1", "node1", "up 2", "2-3"}, []string{"-1", "delta: -1", "(-1)"}},
+		{"3", []string{"3", "3.0", "= 3"}, []string{"4"}},
+	})
+
+	for _, expr := range []string{">= x", "1..", "a..b"} {
+		if _, err := numberMatcher(expr); err == nil {
+			t.Errorf("%s: expecting an error", expr)
+		}
+	}
+}
+
+func TestVersionMatcher(t *testing.T) {
+	runMatcherTests(t, versionMatcher, []matcherTest{
+		{">= 1.13, < 2.0", []string{"1.13.0", "v1.20.5", "2.0.0-rc1"}, []string{"1.12.9", "2.0.0"}},
+		{"~1.13", []string{"1.13.0", "1.13.9"}, []string{"1.12.0", "1.14.0"}},
+		{"~1", []string{"1.0.0", "1.9.0"}, []string{"2.0.0"}},
+		{"^1.2.3", []string{"1.2.3", "1.9.0"}, []string{"1.2.2", "2.0.0"}},
+		{"^0.2.0", []string{"0.2.0", "0.2.9"}, []string{"0.1.9", "0.3.0", "0.9.0", "1.0.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.2", "0.0.4", "0.1.0"}},
+		{"^0.0", []string{"0.0.0", "0.0.9"}, []string{"0.1.0"}},
+		{"^0", []string{"0.0.1", "0.9.0"}, []string{"1.0.0"}},
+		{"^2", []string{"2.0.0", "2.5"}, []string{"1.9.9", "3.0.0"}},
+		{"^1.13 || ^2.0", []string{"1.13.1", "2.1.0"}, []string{"1.12.0", "3.0.0"}},
+		{"== 1.2.3", []string{"build 2024 v1.2.3", "go version go1.2.3 linux/amd64", "1.2.3 (2024)"}, []string{"1.2.30", "11.2.3"}},
+		{"> 1.0.0-alpha", []string{"1.0.0-beta", "1.0.0-alpha.1", "1.0.0"}, []string{"1.0.0-alpha", "0.9.0"}},
+		{"< 1.0.0", []string{"1.0.0-rc.2"}, []string{"1.0.0"}},
+	})
+
+	m, _ := versionMatcher(">= 2.0")
+	if reason := m("build 2024 v1.2.3"); reason != "v1.2.3 does not satisfy '>= 2.0'" {
+		t.Errorf("Expecting the version to be reported, got: %s", reason)
+	}
+	if reason := m("no version here"); !strings.Contains(reason, "is not a version") {
+		t.Errorf("Expecting a missing version, got: %s", reason)
+	}
+	if _, err := versionMatcher("^x"); err == nil {
+		t.Errorf("Expecting an invalid constraint error")
+	}
+}
+
+func TestJSONMatcher(t *testing.T) {
+	yes, no := true, false
+	healthy := "HEALTHY"
+	m, err := jsonMatcher([]JSONAssertion{
+		{Path: ".health", Equals: &healthy},
+		{Path: ".nodes | length", Number: ">= 2"},
+		{Path: ".nodes[-1].name", Match: "^agent-"},
+		{Path: `.["dcos-version"]`, Version: "^2.0"},
+		{Path: ".nodes[0].role", OneOf: []string{"master", "agent"}},
+		{Path: ".maintenance", Exists: &no},
+		{Path: ".nodes", Exists: &yes},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passing := `{"health": "HEALTHY", "dcos-version": "2.1.0", "nodes": [{"name": "master-1", "role": "master"}, {"name": "agent-1", "role": "agent"}]}`
+	if reason := m(passing); reason != "" {
+		t.Errorf("Expecting the document to match, got: %s", reason)
+	}
+
+	failing := `{"health": "UNHEALTHY", "dcos-version": "1.13.0", "maintenance": true, "nodes": [{"name": "master-1", "role": "public"}]}`
+	expected := []string{
+		`.health: "UNHEALTHY" is not "HEALTHY"`,
+		".nodes | length: 1 does not satisfy '>= 2'",
+		`.nodes[-1].name: "master-1" does not match /^agent-/`,
+		`.["dcos-version"]: 1.13.0 does not satisfy '^2.0'`,
+		`.nodes[0].role: "public" is not one of ["master" "agent"]`,
+		".maintenance: exists",
+	}
+	if reason := m(failing); reason != strings.Join(expected, "\n") {
+		t.Errorf("Expecting:\n%s\ngot:\n%s", strings.Join(expected, "\n"), reason)
+	}
+
+	if reason := m(`{"health": "HEALTHY"}`); !strings.Contains(reason, ".nodes: does not exist") {
+		t.Errorf("Expecting the missing path to be reported, got: %s", reason)
+	}
+	if reason := m("not json"); !strings.Contains(reason, "not valid JSON") {
+		t.Errorf("Expecting invalid JSON to be reported, got: %s", reason)
+	}
+
+	for _, path := range []string{"health", ".nodes[0", ".a | keys", ".."} {
+		if _, err := jsonMatcher([]JSONAssertion{{Path: path}}); err == nil {
+			t.Errorf("%s: expecting an invalid path error", path)
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The time to wait for a notifier to complete
+const NotifyTimeout = 10 * time.Second
+
+// The events that are notified
+const (
+	NotifyItemFailed = "item_failed"
+	NotifyVerdict    = "verdict"
+)
+
+/**
+ * A notifier, as configured in the `notify` section of a checklist file.
+ * Exactly one of `webhook`, `slack` or `command` must be given.
+ */
+type NotifySpec struct {
+	// The URL to post the event to, as JSON
+	Webhook string `yaml:"webhook"`
+
+	// The URL of a Slack-compatible incoming webhook
+	Slack string `yaml:"slack"`
+
+	// A shell command to run, with the event as JSON in the standard input
+	Command string `yaml:"command"`
+
+	// The template of the JSON body posted to the `webhook`, with `{{name}}`
+	// placeholders for the fields of the event
+	Template string `yaml:"template"`
+
+	// Additional headers of the `webhook` requests
+	Headers map[string]string `yaml:"headers"`
+
+	// The events to notify: `item_failed` and/or `verdict` (default: both)
+	On []string `yaml:"on"`
+}
+
+/**
+ * Checks that the notifier is well-defined
+ */
+func (s *NotifySpec) Check() error {
+	kinds := 0
+	for _, target := range []string{s.Webhook, s.Slack, s.Command} {
+		if target != "" {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return fmt.Errorf("Expecting exactly one of `webhook`, `slack` or `command`")
+	}
+	if s.Webhook == "" && (s.Template != "" || len(s.Headers) > 0) {
+		return fmt.Errorf("The `template` and `headers` can only be used with `webhook`")
+	}
+	for _, event := range s.On {
+		if event != NotifyItemFailed && event != NotifyVerdict {
+			return fmt.Errorf("Unknown event '%s' (expecting %s or %s)", event, NotifyItemFailed, NotifyVerdict)
+		}
+	}
+	if s.Template != "" {
+		_, err := renderNotifyTemplate(s.Template, &NotifyEvent{})
+		if err != nil {
+			return fmt.Errorf("Invalid template: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+/**
+ * Checks if the given event is notified
+ */
+func (s *NotifySpec) Accepts(event string) bool {
+	if len(s.On) == 0 {
+		return true
+	}
+	for _, e := range s.On {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * An event of a checklist run: either the failure of an item, or the final
+ * verdict of the run
+ */
+type NotifyEvent struct {
+	Event     string    `json:"event"`
+	Checklist string    `json:"checklist"`
+	Time      time.Time `json:"time"`
+
+	// The failed item, for the `item_failed` events
+	Item    string `json:"item,omitempty"`
+	Outcome string `json:"outcome,omitempty"`
+	Value   string `json:"value,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+
+	// The outcome of the run, for the `verdict` events
+	Passed bool `json:"passed"`
+	Failed int  `json:"failed"`
+	Total  int  `json:"total"`
+}
+
+/**
+ * Returns the fields of the event, as given to the notifier templates and
+ * commands. All the fields are defined for every event.
+ */
+func (e *NotifyEvent) fields() map[string]string {
+	return map[string]string{
+		"event":     e.Event,
+		"checklist": e.Checklist,
+		"time":      e.Time.Format(time.RFC3339),
+		"item":      e.Item,
+		"outcome":   e.Outcome,
+		"value":     MaskSecrets(e.Value),
+		"reason":    MaskSecrets(e.Reason),
+		"passed":    strconv.FormatBool(e.Passed),
+		"failed":    strconv.Itoa(e.Failed),
+		"total":     strconv.Itoa(e.Total),
+	}
+}
+
+/**
+ * Sends the events of a checklist run to an external service
+ */
+type Notifier interface {
+	// A short description of the notifier, used in error messages
+	Name() string
+
+	Notify(event *NotifyEvent) error
+}
+
+/**
+ * Renders the template of a webhook, escaping the values of the fields to be
+ * used in JSON strings. The result must be valid JSON.
+ */
+func renderNotifyTemplate(template string, event *NotifyEvent) ([]byte, error) {
+	fields := event.fields()
+	for name, value := range fields {
+		quoted, _ := json.Marshal(value)
+		fields[name] = string(quoted[1 : len(quoted)-1])
+	}
+
+	body, err := Interpolate(template, fields)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid([]byte(body)) {
+		return nil, fmt.Errorf("The rendered template is not valid JSON")
+	}
+	return []byte(body), nil
+}
+
+/**
+ * Posts the given JSON body to the URL, failing if the response is not 2xx.
+ * The errors leave out the URL, since webhook URLs usually embed a token.
+ */
+func postJSON(target string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequest("POST", target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Could not compose request: %s", withoutURL(err).Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	client := &http.Client{Timeout: NotifyTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Could not place request: %s", withoutURL(err).Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Server replied with HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+/**
+ * Returns the underlying error of a URL error, which includes the URL, and
+ * masks the secrets in any other error
+ */
+func withoutURL(err error) error {
+	if uerr, ok := err.(*url.Error); ok {
+		return fmt.Errorf("%s: %s", uerr.Op, MaskSecrets(uerr.Err.Error()))
+	}
+	return fmt.Errorf("%s", MaskSecrets(err.Error()))
+}
+
+/**
+ * Posts the events to a URL, either as JSON or rendered with a template
+ */
+type WebhookNotifier struct {
+	URL      string
+	Template string
+	Headers  map[string]string
+}
+
+func (n *WebhookNotifier) Name() string {
+	return "webhook " + MaskSecrets(n.URL)
+}
+
+func (n *WebhookNotifier) Notify(event *NotifyEvent) error {
+	var body []byte
+	var err error
+	if n.Template != "" {
+		body, err = renderNotifyTemplate(n.Template, event)
+	} else {
+		masked := *event
+		masked.Value = MaskSecrets(event.Value)
+		masked.Reason = MaskSecrets(event.Reason)
+		body, err = json.Marshal(&masked)
+	}
+	if err != nil {
+		return err
+	}
+	return postJSON(n.URL, body, n.Headers)
+}
+
+/**
+ * Posts the events as messages to a Slack-compatible incoming webhook
+ */
+type SlackNotifier struct {
+	URL string
+}
+
+func (n *SlackNotifier) Name() string {
+	return "slack " + MaskSecrets(n.URL)
+}
+
+func (n *SlackNotifier) Notify(event *NotifyEvent) error {
+	var text string
+	switch {
+	case event.Event == NotifyItemFailed:
+		text = fmt.Sprintf(":x: *%s*: %s", event.Checklist, event.Item)
+		if value := MaskSecrets(event.Value); value != "" {
+			text += fmt.Sprintf("\n```%s```", value)
+		}
+		if reason := MaskSecrets(event.Reason); reason != "" {
+			text += "\n" + reason
+		}
+	case event.Passed:
+		text = fmt.Sprintf(":white_check_mark: *%s*: All %d checks are passing", event.Checklist, event.Total)
+	default:
+		text = fmt.Sprintf(":rotating_light: *%s*: %d of %d checks have failed", event.Checklist, event.Failed, event.Total)
+	}
+
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	return postJSON(n.URL, body, nil)
+}
+
+/**
+ * Runs a shell command for every event, with the event as JSON in the
+ * standard input and its fields in `PREFLIGHTER_<FIELD>` variables
+ */
+type CommandNotifier struct {
+	Command string
+	Env     []string
+}
+
+func (n *CommandNotifier) Name() string {
+	return "command '" + n.Command + "'"
+}
+
+func (n *CommandNotifier) Notify(event *NotifyEvent) error {
+	masked := *event
+	masked.Value = MaskSecrets(event.Value)
+	masked.Reason = MaskSecrets(event.Reason)
+	input, err := json.Marshal(&masked)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), NotifyTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "bash", "-c", n.Command)
+	cmd.Env = append(os.Environ(), n.Env...)
+	for name, value := range event.fields() {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("PREFLIGHTER_%s=%s", strings.ToUpper(name), value))
+	}
+	cmd.Stdin = bytes.NewReader(input)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s", err.Error(), MaskSecrets(strings.TrimSpace(string(out))))
+	}
+	return nil
+}
+
+/**
+ * The notifiers of a checklist run, each with the events it's interested in
+ */
+type Notifiers struct {
+	specs     []NotifySpec
+	notifiers []Notifier
+
+	// The events sent in the background, and the errors of sending them
+	queue   chan *NotifyEvent
+	flushed chan struct{}
+	errs    []error
+	once    sync.Once
+}
+
+/**
+ * Creates the notifiers configured in the checklist files, expanding the
+ * `${NAME}` references in their URLs, commands and headers
+ */
+func (c *Config) CreateNotifiers() (*Notifiers, error) {
+	n := &Notifiers{}
+	for i := range c.Notify {
+		spec := c.Notify[i]
+		err := spec.Check()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid notifier: %s", err.Error())
+		}
+
+		var notifier Notifier
+		switch {
+		case spec.Webhook != "":
+			headers := make(map[string]string)
+			for k, v := range spec.Headers {
+				headers[k] = c.expandVarRefs(v)
+			}
+			notifier = &WebhookNotifier{
+				URL:      c.expandVarRefs(spec.Webhook),
+				Template: spec.Template,
+				Headers:  headers,
+			}
+		case spec.Slack != "":
+			notifier = &SlackNotifier{URL: c.expandVarRefs(spec.Slack)}
+		default:
+			notifier = &CommandNotifier{Command: spec.Command, Env: c.GetEnvList()}
+		}
+
+		n.specs = append(n.specs, spec)
+		n.notifiers = append(n.notifiers, notifier)
+	}
+	return n, nil
+}
+
+/**
+ * Sends the event to all the notifiers interested in it, returning the
+ * errors of the notifiers that failed
+ */
+func (n *Notifiers) Notify(event *NotifyEvent) []error {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	var errs []error
+	for i, notifier := range n.notifiers {
+		if !n.specs[i].Accepts(event.Event) {
+			continue
+		}
+		err := notifier.Notify(event)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Could not notify %s: %s", notifier.Name(), err.Error()))
+		}
+	}
+	return errs
+}
+
+/**
+ * Sends the event to the notifiers in the background, in the order the
+ * events are queued, so that slow or unreachable notifiers don't hold up
+ * the run. The errors are returned by Flush.
+ */
+func (n *Notifiers) Queue(event *NotifyEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	n.once.Do(func() {
+		n.queue = make(chan *NotifyEvent, 64)
+		n.flushed = make(chan struct{})
+		go func() {
+			defer close(n.flushed)
+			for event := range n.queue {
+				n.errs = append(n.errs, n.Notify(event)...)
+			}
+		}()
+	})
+	n.queue <- event
+}
+
+/**
+ * Waits for the events queued in the background to be sent, returning the
+ * errors of the notifiers that failed. No more events can be queued.
+ */
+func (n *Notifiers) Flush() []error {
+	n.once.Do(func() {})
+	if n.queue == nil {
+		return nil
+	}
+
+	close(n.queue)
+	<-n.flushed
+	n.queue = nil
+	return n.errs
+}
@@ -0,0 +1,204 @@
+package util
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNotifySpecCheck(t *testing.T) {
+	tests := []struct {
+		spec     NotifySpec
+		expected string
+	}{
+		{NotifySpec{Webhook: "http://a"}, ""},
+		{NotifySpec{Slack: "http://a", On: []string{NotifyVerdict}}, ""},
+		{NotifySpec{Command: "true", On: []string{NotifyItemFailed, NotifyVerdict}}, ""},
+		{NotifySpec{Webhook: "http://a", Template: `{"text": "{{item}}"}`}, ""},
+		{NotifySpec{}, "Expecting exactly one of"},
+		{NotifySpec{Webhook: "http://a", Slack: "http://b"}, "Expecting exactly one of"},
+		{NotifySpec{Slack: "http://a", Template: "{}"}, "can only be used with `webhook`"},
+		{NotifySpec{Command: "true", Headers: map[string]string{"a": "b"}}, "can only be used with `webhook`"},
+		{NotifySpec{Command: "true", On: []string{"done"}}, "Unknown event 'done'"},
+		{NotifySpec{Webhook: "http://a", Template: `{"text": "{{nope}}"}`}, "Undefined variable 'nope'"},
+		{NotifySpec{Webhook: "http://a", Template: `{"text": {{item}}}`}, "not valid JSON"},
+	}
+
+	for _, test := range tests {
+		err := test.spec.Check()
+		if test.expected == "" {
+			if err != nil {
+				t.Errorf("%+v: unexpected error: %s", test.spec, err.Error())
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%+v: expecting an error with %q, got: %v", test.spec, test.expected, err)
+		}
+	}
+}
+
+func TestNotifySpecAccepts(t *testing.T) {
+	all := NotifySpec{}
+	if !all.Accepts(NotifyItemFailed) || !all.Accepts(NotifyVerdict) {
+		t.Errorf("Expecting all the events to be accepted by default")
+	}
+	verdict := NotifySpec{On: []string{NotifyVerdict}}
+	if verdict.Accepts(NotifyItemFailed) || !verdict.Accepts(NotifyVerdict) {
+		t.Errorf("Expecting only the verdict to be accepted")
+	}
+}
+
+func TestRenderNotifyTemplate(t *testing.T) {
+	AddSecret("notify-secret")
+	event := &NotifyEvent{
+		Event:     NotifyItemFailed,
+		Checklist: "Checklist",
+		Item:      `Is "this" a \ path?`,
+		Value:     "line 1\nline 2\ttab notify-secret",
+		Failed:    2,
+	}
+
+	body, err := renderNotifyTemplate(`{"text": "{{item}}: {{value}}", "failed": {{failed}}, "passed": {{passed}}}`, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded struct {
+		Text   string `json:"text"`
+		Failed int    `json:"failed"`
+		Passed bool   `json:"passed"`
+	}
+	err = json.Unmarshal(body, &decoded)
+	if err != nil {
+		t.Fatalf("Could not decode %s: %s", body, err.Error())
+	}
+	expected := `Is "this" a \ path?: line 1` + "\nline 2\ttab " + SecretMask
+	if decoded.Text != expected {
+		t.Errorf("Expecting %q, got %q", expected, decoded.Text)
+	}
+	if decoded.Failed != 2 || decoded.Passed {
+		t.Errorf("Unexpected values in %s", body)
+	}
+
+	_, err = renderNotifyTemplate(`{"text": "{{item}}"`, event)
+	if err == nil {
+		t.Errorf("Expecting an invalid JSON error")
+	}
+}
+
+/**
+ * Starts a server recording the bodies posted to it, after the given delay
+ */
+func startNotifyServer(delay time.Duration) (*httptest.Server, func() []string) {
+	var lock sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		body, _ := ioutil.ReadAll(r.Body)
+		lock.Lock()
+		bodies = append(bodies, string(body))
+		lock.Unlock()
+		if r.URL.Path == "/fail" {
+			http.Error(w, "failed", http.StatusInternalServerError)
+		}
+	}))
+	return server, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string(nil), bodies...)
+	}
+}
+
+func TestSlackNotifier(t *testing.T) {
+	server, bodies := startNotifyServer(0)
+	defer server.Close()
+
+	n := &SlackNotifier{URL: server.URL}
+	err := n.Notify(&NotifyEvent{Event: NotifyVerdict, Checklist: "Checklist", Failed: 1, Total: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"text":":rotating_light: *Checklist*: 1 of 3 checks have failed"}`
+	if got := bodies(); len(got) != 1 || got[0] != expected {
+		t.Errorf("Expecting %s, got %v", expected, got)
+	}
+}
+
+func TestPostJSONHidesURL(t *testing.T) {
+	// The server is gone, so the request can not be placed
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	for _, target := range []string{
+		server.URL + "/hooks/webhook-token",
+		"http://[::1/hooks/webhook-token",
+	} {
+		err := postJSON(target, []byte("{}"), nil)
+		if err == nil || strings.Contains(err.Error(), "webhook-token") {
+			t.Errorf("Expecting an error without the URL, got: %v", err)
+		}
+	}
+}
+
+func TestCommandNotifier(t *testing.T) {
+	n := &CommandNotifier{Command: `[ "$PREFLIGHTER_ITEM" = "Item" ] && grep -q '"event":"item_failed"'`}
+	err := n.Notify(&NotifyEvent{Event: NotifyItemFailed, Item: "Item"})
+	if err != nil {
+		t.Errorf("Expecting the command to get the event, got: %s", err.Error())
+	}
+
+	n = &CommandNotifier{Command: "echo broken; exit 3"}
+	err = n.Notify(&NotifyEvent{Event: NotifyVerdict})
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("Expecting the output of the failed command, got: %v", err)
+	}
+}
+
+func TestNotifiersQueue(t *testing.T) {
+	server, bodies := startNotifyServer(50 * time.Millisecond)
+	defer server.Close()
+
+	config, _ := CreateConfig()
+	config.Notify = []NotifySpec{
+		{Webhook: server.URL + "/ok", On: []string{NotifyItemFailed}},
+		{Webhook: server.URL + "/fail", On: []string{NotifyVerdict}},
+	}
+	notifiers, err := config.CreateNotifiers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Queuing does not wait for the notifiers
+	started := time.Now()
+	for _, item := range []string{"a", "b", "c"} {
+		notifiers.Queue(&NotifyEvent{Event: NotifyItemFailed, Item: item})
+	}
+	if time.Since(started) >= 50*time.Millisecond {
+		t.Errorf("Expecting the events to be sent in the background")
+	}
+
+	errs := notifiers.Flush()
+	if len(errs) != 0 {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+	got := bodies()
+	if len(got) != 3 {
+		t.Fatalf("Expecting 3 events, got %d", len(got))
+	}
+	for i, item := range []string{"a", "b", "c"} {
+		if !strings.Contains(got[i], `"item":"`+item+`"`) {
+			t.Errorf("Expecting the events in order, got %s at %d", got[i], i)
+		}
+	}
+
+	errs = notifiers.Notify(&NotifyEvent{Event: NotifyVerdict})
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "HTTP 500") {
+		t.Errorf("Expecting the failed verdict notifier to be reported, got: %v", errs)
+	}
+}
@@ -0,0 +1,49 @@
+package util
+
+/**
+ * The way a checklist item was resolved
+ */
+type Outcome int
+
+const (
+	OutcomeNone Outcome = iota
+	OutcomePass
+	OutcomeFail
+	OutcomeSkip
+	OutcomeTimeout
+	OutcomeAborted
+	OutcomeNoChecks
+	OutcomeBlocked
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomePass:
+		return "pass"
+	case OutcomeFail:
+		return "fail"
+	case OutcomeSkip:
+		return "skip"
+	case OutcomeTimeout:
+		return "timeout"
+	case OutcomeAborted:
+		return "aborted"
+	case OutcomeNoChecks:
+		return "no-checks"
+	case OutcomeBlocked:
+		return "blocked"
+	}
+	return "none"
+}
+
+/**
+ * Parses the string representation of an outcome
+ */
+func ParseOutcome(text string) Outcome {
+	for o := OutcomePass; o <= OutcomeBlocked; o++ {
+		if o.String() == text {
+			return o
+		}
+	}
+	return OutcomeNone
+}
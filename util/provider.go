@@ -0,0 +1,86 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+)
+
+/**
+ * A Provider abstracts the platform the checklists are probing, contributing
+ * the environment variables, the tools and the bash helper functions that the
+ * probe scripts can use.
+ */
+type Provider interface {
+	// The name used in the `provider:` key of the checklist file
+	Name() string
+
+	// The environment variables exposed to the probe scripts
+	Env() (map[string]string, error)
+
+	// The executables that must exist in $PATH
+	RequiredTools() []string
+
+	// The bash helper functions available to the probe scripts
+	BashLibrary() string
+
+	// The names of the environment variables holding credentials, that must
+	// be masked from all output
+	Secrets() []string
+}
+
+/**
+ * The provider to use when the checklist does not specify one
+ */
+const DefaultProvider = "dcos"
+
+var providers = map[string]func() Provider{
+	"dcos":       func() Provider { return &DcosProvider{} },
+	"kubernetes": func() Provider { return &KubernetesProvider{} },
+	"none":       func() Provider { return &NoneProvider{} },
+}
+
+/**
+ * Returns a new instance of the provider with the given name
+ */
+func CreateProvider(name string) (Provider, error) {
+	if name == "" {
+		name = DefaultProvider
+	}
+
+	factory, ok := providers[name]
+	if !ok {
+		var names []string
+		for k := range providers {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("Unknown provider '%s' (expecting one of: %v)", name, names)
+	}
+
+	return factory(), nil
+}
+
+/**
+ * The provider used for checklists that do not need any platform integration
+ */
+type NoneProvider struct{}
+
+func (p *NoneProvider) Name() string {
+	return "none"
+}
+
+func (p *NoneProvider) Env() (map[string]string, error) {
+	return nil, nil
+}
+
+func (p *NoneProvider) RequiredTools() []string {
+	return nil
+}
+
+func (p *NoneProvider) BashLibrary() string {
+	return ""
+}
+
+func (p *NoneProvider) Secrets() []string {
+	return nil
+}
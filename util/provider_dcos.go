@@ -0,0 +1,129 @@
+package util
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+/**
+ * The DC/OS provider uses the configuration of the `dcos` CLI to reach the
+ * cluster
+ */
+type DcosProvider struct{}
+
+func (p *DcosProvider) Name() string {
+	return "dcos"
+}
+
+func (p *DcosProvider) Env() (map[string]string, error) {
+	env := make(map[string]string)
+
+	// Get the cluster URL
+	out, err := exec.Command("dcos", "config", "show", "core.dcos_url").Output()
+	if err != nil {
+		return nil, fmt.Errorf("Could not get cluster: %s", err.Error())
+	}
+	env["DCOS_URL"] = strings.Trim(string(out), "\r\n\t ")
+
+	// Get the ACS token
+	out, err = exec.Command("dcos", "config", "show", "core.dcos_acs_token").Output()
+	if err != nil {
+		return nil, fmt.Errorf("Could not get cluster: %s", err.Error())
+	}
+	env["DCOS_ACS_TOKEN"] = strings.Trim(string(out), "\r\n\t ")
+
+	return env, nil
+}
+
+func (p *DcosProvider) RequiredTools() []string {
+	return []string{
+		"curl",
+		"dcos",
+		"jq",
+	}
+}
+
+func (p *DcosProvider) BashLibrary() string {
+	return dcosBashLibrary
+}
+
+func (p *DcosProvider) Secrets() []string {
+	return []string{"DCOS_ACS_TOKEN"}
+}
+
+var dcosBashLibrary = `
+# Shorthand to 'curl -H <Auth> <DCOS_URL>/'
+function cluster_curl() {
+  local URL=$1; shift
+  curl $* -k -f -L -sS -H "Authorization: token=${DCOS_ACS_TOKEN}" ${DCOS_URL}/${URL}
+}
+function cached_cluster_curl() {
+  local URL=$1; shift
+  local CACHE_ID=$(echo "${DCOS_URL}|curl|${URL}" | shasum - | awk '{print $1}')
+  echo "[curl] Using cache ID: $CACHE_ID" >&2
+  local CACHE_FILE="${CACHE_DIR}/${CACHE_ID}"
+  if [ ! -f "${CACHE_FILE}" ]; then
+    local TEMP_FILE="${CACHE_FILE}.$$.${RANDOM}"
+    cluster_curl $URL $* > ${TEMP_FILE}
+    RET=$?
+    if [ $RET -ne 0 ]; then
+      rm ${TEMP_FILE}
+      return $RET
+    fi
+    mv ${TEMP_FILE} ${CACHE_FILE}
+  fi
+  cat ${CACHE_FILE}
+}
+
+# Perform a bash command on the specified node, making sure only the
+# standard output of the command given will be returned
+function node_ssh() {
+  local NODE_SELECTOR=$1; shift
+  dcos node ssh \
+    $NODE_SELECTOR \
+    --master-proxy \
+    --option UserKnownHostsFile=/dev/null \
+    --option StrictHostKeyChecking=no \
+    --option BatchMode=yes \
+    --user=centos \
+    "$* 2>&1" | tr '\r' '\n'
+  return ${PIPESTATUS[0]}
+}
+function cached_node_ssh() {
+  local CACHE_ID
+  local CACHE_FILE
+  CACHE_ID=$(echo "${DCOS_URL}|ssh|$*" | shasum - | awk '{print $1}')
+  echo "[ssh] Using cache ID: $CACHE_ID" >&2
+  CACHE_FILE="${CACHE_DIR}/${CACHE_ID}"
+  if [ ! -f "${CACHE_FILE}" ]; then
+    local TEMP_FILE="${CACHE_FILE}.$$.${RANDOM}"
+    node_ssh $* > ${TEMP_FILE}
+    RET=$?
+    if [ $RET != 0 ]; then
+      rm ${TEMP_FILE}
+      return $RET
+    fi
+    mv ${TEMP_FILE} ${CACHE_FILE}
+  fi
+  cat ${CACHE_FILE}
+}
+
+# Cached call to 'dcos ...'
+function cached_dcos() {
+  local CACHE_ID=$(echo "${DCOS_URL}|dcos|$*" | shasum - | awk '{print $1}')
+  echo "[dcos] Using cache ID: $CACHE_ID" >&2
+  local CACHE_FILE="${CACHE_DIR}/${CACHE_ID}"
+  if [ ! -f "${CACHE_FILE}" ]; then
+    local TEMP_FILE="${CACHE_FILE}.$$.${RANDOM}"
+    dcos $* > ${TEMP_FILE}
+    RET=$?
+    if [ $RET != 0 ]; then
+      rm ${TEMP_FILE}
+      return $RET
+    fi
+    mv ${TEMP_FILE} ${CACHE_FILE}
+  fi
+  cat ${CACHE_FILE}
+}
+`
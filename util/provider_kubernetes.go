@@ -0,0 +1,158 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/**
+ * The Kubernetes provider uses the current context of the kubeconfig to reach
+ * the cluster
+ */
+type KubernetesProvider struct{}
+
+type kubeConfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Contexts       []struct {
+		Name    string
+		Context struct {
+			Cluster   string
+			Namespace string
+		}
+	}
+	Clusters []struct {
+		Name    string
+		Cluster struct {
+			Server string
+		}
+	}
+}
+
+/**
+ * Returns the path to the kubeconfig file, the same way `kubectl` does
+ */
+func kubeConfigPath() string {
+	if env := os.Getenv("KUBECONFIG"); env != "" {
+		return filepath.SplitList(env)[0]
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".kube", "config")
+}
+
+func (p *KubernetesProvider) Name() string {
+	return "kubernetes"
+}
+
+func (p *KubernetesProvider) Env() (map[string]string, error) {
+	env := make(map[string]string)
+
+	path := kubeConfigPath()
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read kubeconfig %s: %s", path, err.Error())
+	}
+
+	var kc kubeConfig
+	err = yaml.Unmarshal(content, &kc)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse kubeconfig %s: %s", path, err.Error())
+	}
+	if kc.CurrentContext == "" {
+		return nil, fmt.Errorf("There is no current context in kubeconfig %s", path)
+	}
+
+	env["KUBECONFIG"] = path
+	env["KUBE_CONTEXT"] = kc.CurrentContext
+	env["KUBE_NAMESPACE"] = "default"
+
+	for _, ctx := range kc.Contexts {
+		if ctx.Name != kc.CurrentContext {
+			continue
+		}
+		if ctx.Context.Namespace != "" {
+			env["KUBE_NAMESPACE"] = ctx.Context.Namespace
+		}
+		for _, cluster := range kc.Clusters {
+			if cluster.Name == ctx.Context.Cluster {
+				env["KUBE_URL"] = strings.TrimRight(cluster.Cluster.Server, "/")
+			}
+		}
+	}
+	if env["KUBE_URL"] == "" {
+		return nil, fmt.Errorf("Could not find the cluster of context '%s' in kubeconfig %s", kc.CurrentContext, path)
+	}
+
+	return env, nil
+}
+
+func (p *KubernetesProvider) RequiredTools() []string {
+	return []string{
+		"jq",
+		"kubectl",
+	}
+}
+
+func (p *KubernetesProvider) BashLibrary() string {
+	return kubernetesBashLibrary
+}
+
+/**
+ * The credentials remain in the kubeconfig file and are never exposed in
+ * the environment
+ */
+func (p *KubernetesProvider) Secrets() []string {
+	return nil
+}
+
+var kubernetesBashLibrary = `
+# Shorthand to 'kubectl get --raw /<path>', using the credentials of the
+# current kubeconfig context
+function cluster_curl() {
+  local URL=$1; shift
+  kubectl --context "${KUBE_CONTEXT}" get --raw "/${URL}" $*
+}
+function cached_cluster_curl() {
+  local URL=$1; shift
+  local CACHE_ID=$(echo "${KUBE_URL}|curl|${URL}" | shasum - | awk '{print $1}')
+  echo "[curl] Using cache ID: $CACHE_ID" >&2
+  local CACHE_FILE="${CACHE_DIR}/${CACHE_ID}"
+  if [ ! -f "${CACHE_FILE}" ]; then
+    local TEMP_FILE="${CACHE_FILE}.$$.${RANDOM}"
+    cluster_curl $URL $* > ${TEMP_FILE}
+    RET=$?
+    if [ $RET -ne 0 ]; then
+      rm ${TEMP_FILE}
+      return $RET
+    fi
+    mv ${TEMP_FILE} ${CACHE_FILE}
+  fi
+  cat ${CACHE_FILE}
+}
+
+# Cached call to 'kubectl ...' against the current context
+function cached_kubectl() {
+  local CACHE_ID=$(echo "${KUBE_URL}|kubectl|$*" | shasum - | awk '{print $1}')
+  echo "[kubectl] Using cache ID: $CACHE_ID" >&2
+  local CACHE_FILE="${CACHE_DIR}/${CACHE_ID}"
+  if [ ! -f "${CACHE_FILE}" ]; then
+    local TEMP_FILE="${CACHE_FILE}.$$.${RANDOM}"
+    kubectl --context "${KUBE_CONTEXT}" $* > ${TEMP_FILE}
+    RET=$?
+    if [ $RET != 0 ]; then
+      rm ${TEMP_FILE}
+      return $RET
+    fi
+    mv ${TEMP_FILE} ${CACHE_FILE}
+  fi
+  cat ${CACHE_FILE}
+}
+`
@@ -0,0 +1,262 @@
+package util
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/**
+ * A machine-readable record of a checklist run
+ */
+type Report struct {
+	Title      string       `json:"title"`
+	Started    time.Time    `json:"started"`
+	Duration   float64      `json:"duration"`
+	Unattended bool         `json:"unattended"`
+	Passed     bool         `json:"passed"`
+	Items      []ReportItem `json:"items"`
+}
+
+/**
+ * The record of a single checklist item in the report
+ */
+type ReportItem struct {
+	Title    string  `json:"title"`
+	Source   string  `json:"source"`
+	Stdout   string  `json:"stdout"`
+	Stderr   string  `json:"stderr"`
+	ExitCode int     `json:"exit_code"`
+	Expect   string  `json:"expect,omitempty"`
+	Details  string  `json:"expect_details,omitempty"`
+	Duration float64 `json:"duration"`
+	Decision string  `json:"decision"`
+	Reason   string  `json:"reason,omitempty"`
+
+	Attempts []ReportAttempt `json:"attempts,omitempty"`
+}
+
+/**
+ * The record of an attempt of an item that was retried
+ */
+type ReportAttempt struct {
+	Stdout   string  `json:"stdout"`
+	Stderr   string  `json:"stderr"`
+	ExitCode int     `json:"exit_code"`
+	Duration float64 `json:"duration"`
+	Error    string  `json:"error,omitempty"`
+	Details  string  `json:"expect_details,omitempty"`
+}
+
+func CreateReport(title string, unattended bool) *Report {
+	return &Report{
+		Title:      title,
+		Started:    time.Now(),
+		Unattended: unattended,
+	}
+}
+
+/**
+ * Records the result of the given item
+ */
+func (r *Report) AddItem(item *ChecklistItem, res *CheckResult) {
+	var attempts []ReportAttempt
+	if len(res.Attempts) > 1 {
+		for _, a := range res.Attempts {
+			attempts = append(attempts, ReportAttempt{
+				Stdout:   MaskSecrets(a.Stdout),
+				Stderr:   MaskSecrets(a.Stderr),
+				ExitCode: a.ExitCode,
+				Duration: a.Duration.Seconds(),
+				Error:    MaskSecrets(a.Error),
+				Details:  MaskSecrets(a.Details),
+			})
+		}
+	}
+
+	r.Items = append(r.Items, ReportItem{
+		Title:    item.Title,
+		Source:   item.Filename,
+		Stdout:   MaskSecrets(res.Stdout),
+		Stderr:   MaskSecrets(res.Stderr),
+		ExitCode: res.ExitCode,
+		Expect:   res.Expect,
+		Details:  MaskSecrets(res.ExpectDetails),
+		Duration: res.Duration.Seconds(),
+		Decision: res.Outcome.String(),
+		Reason:   MaskSecrets(res.Reason),
+		Attempts: attempts,
+	})
+}
+
+/**
+ * Marks the end of the run with the final verdict
+ */
+func (r *Report) Complete(passed bool) {
+	r.Passed = passed
+	r.Duration = time.Now().Sub(r.Started).Seconds()
+}
+
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestSuites struct {
+	XMLName    xml.Name         `xml:"testsuites"`
+	Name       string           `xml:"name,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Skipped    int              `xml:"skipped,attr"`
+	Time       string           `xml:"time,attr"`
+	TestSuites []junitTestSuite `xml:"testsuite"`
+}
+
+/**
+ * Writes the report in JUnit XML format, with one test suite per source file.
+ * The failed, timed out and aborted items are failures, as they are for the
+ * verdict, and the other unresolved items are skipped.
+ */
+func (r *Report) WriteJUnit(w io.Writer) error {
+	suites := junitTestSuites{
+		Name: r.Title,
+		Time: fmt.Sprintf("%.3f", r.Duration),
+	}
+
+	suiteIndex := make(map[string]int)
+	var suiteTimes []float64
+	for _, item := range r.Items {
+		idx, ok := suiteIndex[item.Source]
+		if !ok {
+			idx = len(suites.TestSuites)
+			suiteIndex[item.Source] = idx
+			suites.TestSuites = append(suites.TestSuites, junitTestSuite{
+				Name:      item.Source,
+				Timestamp: r.Started.Format("2006-01-02T15:04:05"),
+			})
+			suiteTimes = append(suiteTimes, 0)
+		}
+		suite := &suites.TestSuites[idx]
+		suiteTimes[idx] += item.Duration
+
+		tc := junitTestCase{
+			Name:      item.Title,
+			ClassName: item.Source,
+			Time:      fmt.Sprintf("%.3f", item.Duration),
+			SystemOut: item.Stdout,
+			SystemErr: item.Stderr,
+		}
+
+		switch item.Decision {
+		case "fail", "timeout":
+			message := fmt.Sprintf("Item %s (exit code %d)", item.Decision, item.ExitCode)
+			if len(item.Attempts) > 1 {
+				message = fmt.Sprintf("%s after %d attempts", message, len(item.Attempts))
+			}
+			tc.Failure = &junitFailure{
+				Message: message,
+				Type:    item.Decision,
+				Text:    item.Details,
+			}
+			suite.Failures += 1
+		case "aborted":
+			// The run was stopped before the item was resolved, which fails
+			// the checklist all the same
+			tc.Failure = &junitFailure{
+				Message: "Item aborted before it was resolved",
+				Type:    item.Decision,
+				Text:    item.Reason,
+			}
+			suite.Failures += 1
+		case "skip", "no-checks", "blocked":
+			message := item.Decision
+			if item.Reason != "" {
+				message = fmt.Sprintf("%s: %s", message, item.Reason)
+			}
+			tc.Skipped = &junitSkipped{Message: message}
+			suite.Skipped += 1
+		}
+
+		suite.Tests += 1
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+
+	for i := range suites.TestSuites {
+		suite := &suites.TestSuites[i]
+		suite.Time = fmt.Sprintf("%.3f", suiteTimes[i])
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Skipped += suite.Skipped
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(suites)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+/**
+ * Writes the report to the given file, using JUnit XML if the file has an
+ * `.xml` extension, or JSON otherwise
+ */
+func (r *Report) WriteFile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("Could not create report %s: %s", filename, err.Error())
+	}
+	defer f.Close()
+
+	if strings.ToLower(filepath.Ext(filename)) == ".xml" {
+		err = r.WriteJUnit(f)
+	} else {
+		err = r.WriteJSON(f)
+	}
+	if err != nil {
+		return fmt.Errorf("Could not write report %s: %s", filename, err.Error())
+	}
+	return nil
+}
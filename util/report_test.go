@@ -0,0 +1,116 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+/**
+ * Creates a report with one item for each decision, spread over two files
+ */
+func testReport() *Report {
+	AddSecret("report-secret")
+	report := CreateReport("Release", true)
+	results := []struct {
+		title, filename string
+		res             CheckResult
+	}{
+		{"Passed", "a.yaml", CheckResult{Stdout: "ok report-secret", Outcome: OutcomePass, Duration: time.Second}},
+		{"Failed", "a.yaml", CheckResult{ExitCode: 2, Expect: "fail", ExpectDetails: "No match\n", Outcome: OutcomeFail,
+			Attempts: []CheckAttempt{{ExitCode: 1}, {ExitCode: 2}}}},
+		{"Timed out", "a.yaml", CheckResult{ExitCode: -1, TimedOut: true, Outcome: OutcomeTimeout}},
+		{"Aborted", "b.yaml", CheckResult{Outcome: OutcomeAborted, Reason: "Stopped by the operator"}},
+		{"Blocked", "b.yaml", CheckResult{Outcome: OutcomeBlocked, Reason: "Depends on Failed"}},
+		{"Skipped", "b.yaml", CheckResult{Outcome: OutcomeSkip, Reason: "Not applicable"}},
+	}
+	for i := range results {
+		r := &results[i]
+		report.AddItem(&ChecklistItem{Title: r.title, Filename: r.filename}, &r.res)
+	}
+	report.Complete(false)
+	return report
+}
+
+func TestReportWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	err := testReport().WriteJSON(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "report-secret") {
+		t.Errorf("Expecting the secrets to be masked, got %s", buf.String())
+	}
+
+	var decoded Report
+	err = json.Unmarshal(buf.Bytes(), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Title != "Release" || decoded.Passed || !decoded.Unattended || len(decoded.Items) != 6 {
+		t.Fatalf("The report was not decoded correctly: %+v", decoded)
+	}
+	expected := []string{"pass", "fail", "timeout", "aborted", "blocked", "skip"}
+	for i, decision := range expected {
+		if decoded.Items[i].Decision != decision {
+			t.Errorf("Expecting item %d to be %s, got %s", i, decision, decoded.Items[i].Decision)
+		}
+	}
+	if passed := decoded.Items[0]; passed.Stdout != "ok "+SecretMask || passed.Duration != 1 || passed.Source != "a.yaml" {
+		t.Errorf("The passed item was not recorded correctly: %+v", passed)
+	}
+	if failed := decoded.Items[1]; len(failed.Attempts) != 2 || failed.ExitCode != 2 || failed.Details != "No match\n" {
+		t.Errorf("The failed item was not recorded correctly: %+v", failed)
+	}
+}
+
+func TestReportWriteJUnit(t *testing.T) {
+	var buf bytes.Buffer
+	err := testReport().WriteJUnit(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var suites junitTestSuites
+	err = xml.Unmarshal(buf.Bytes(), &suites)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The aborted item fails the run, the blocked and skipped items do not
+	if suites.Tests != 6 || suites.Failures != 3 || suites.Skipped != 2 || len(suites.TestSuites) != 2 {
+		t.Fatalf("Expecting 6 tests, 3 failures and 2 skipped in 2 suites, got %+v", suites)
+	}
+	a, b := suites.TestSuites[0], suites.TestSuites[1]
+	if a.Name != "a.yaml" || a.Tests != 3 || a.Failures != 2 || b.Name != "b.yaml" || b.Failures != 1 || b.Skipped != 2 {
+		t.Errorf("The suites were not counted correctly: %+v, %+v", a, b)
+	}
+
+	failures := map[string]string{"Failed": "fail", "Timed out": "timeout", "Aborted": "aborted"}
+	for _, suite := range suites.TestSuites {
+		for _, tc := range suite.TestCases {
+			failureType, failed := failures[tc.Name]
+			switch {
+			case failed && (tc.Failure == nil || tc.Failure.Type != failureType):
+				t.Errorf("Expecting %s to be a %s failure, got %+v", tc.Name, failureType, tc.Failure)
+			case !failed && tc.Failure != nil:
+				t.Errorf("Expecting %s not to be a failure, got %+v", tc.Name, tc.Failure)
+			}
+		}
+	}
+
+	failed := a.TestCases[1].Failure
+	if failed.Message != "Item fail (exit code 2) after 2 attempts" || failed.Text != "No match\n" {
+		t.Errorf("The failure was not described correctly: %+v", failed)
+	}
+	aborted := b.TestCases[0].Failure
+	if aborted.Text != "Stopped by the operator" {
+		t.Errorf("Expecting the reason of the abort, got %+v", aborted)
+	}
+	if blocked := b.TestCases[1].Skipped; blocked == nil || blocked.Message != "blocked: Depends on Failed" {
+		t.Errorf("Expecting the blocked item to be skipped, got %+v", blocked)
+	}
+}
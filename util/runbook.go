@@ -8,17 +8,110 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
-	"regexp"
 	"strings"
+	"time"
+)
+
+// The time to wait for a response from the runbook API
+const RunbookRequestTimeout = 30 * time.Second
 
-	"github.com/imdario/mergo"
-	"github.com/lithammer/dedent"
+// The number of times a request is retried after a transient failure, and
+// the delay before the first retry (doubling on every retry)
+const RunbookRetries = 3
+const RunbookRetryDelay = 500 * time.Millisecond
+
+/**
+ * The status of a runbook checklist item
+ */
+type RunbookStatus int
+
+const (
+	RunbookPending RunbookStatus = iota
+	RunbookCompleted
+	RunbookFailed
+	RunbookSkipped
 )
 
+func (s RunbookStatus) String() string {
+	switch s {
+	case RunbookPending:
+		return "pending"
+	case RunbookCompleted:
+		return "completed"
+	case RunbookFailed:
+		return "failed"
+	case RunbookSkipped:
+		return "skipped"
+	}
+	return fmt.Sprintf("unknown (%d)", int(s))
+}
+
+/**
+ * Parses a status either from its number or from its name
+ */
+func (s *RunbookStatus) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var number int
+	if err := unmarshal(&number); err == nil {
+		*s = RunbookStatus(number)
+		return nil
+	}
+
+	var name string
+	err := unmarshal(&name)
+	if err != nil {
+		return err
+	}
+	for status := RunbookPending; status <= RunbookSkipped; status++ {
+		if status.String() == name {
+			*s = status
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown runbook status '%s'", name)
+}
+
+/**
+ * An error returned when calling the runbook API
+ */
+type RunbookError struct {
+	Verb    string
+	Path    string
+	Message string
+
+	// The HTTP status code of the response, or 0 if there was no response
+	StatusCode int
+}
+
+func (e *RunbookError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("%s %s: %s", e.Verb, e.Path, e.Message)
+	}
+	return fmt.Sprintf("%s %s: %s (HTTP %d)", e.Verb, e.Path, e.Message, e.StatusCode)
+}
+
+/**
+ * Checks if the request can succeed if retried: when there was no response
+ * at all, the server was overloaded or had an internal error
+ */
+func (e *RunbookError) Transient() bool {
+	return e.StatusCode == 0 ||
+		e.StatusCode == http.StatusTooManyRequests ||
+		e.StatusCode >= 500
+}
+
 type RunbookClient struct {
-	client    *http.Client
-	baseUrl   string
-	authToken string
+	client     *http.Client
+	baseUrl    string
+	authToken  string
+	retries    int
+	retryDelay time.Duration
+
+	// If set, the checklist item updates are queued in this file
+	outbox string
+
+	// If set, called with the problems of the instructions that don't prevent
+	// the checklist from being imported
+	Warn func(message string)
 }
 
 type apiResponse struct {
@@ -36,12 +129,18 @@ type apiResponse struct {
 func CreateRunbookClient(baseUrl string, authToken string) (*RunbookClient, error) {
 	customTransport := http.DefaultTransport.(*http.Transport).Clone()
 	customTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	client := &http.Client{Transport: customTransport}
+	client := &http.Client{
+		Transport: customTransport,
+		Timeout:   RunbookRequestTimeout,
+	}
 
+	AddSecret(authToken)
 	return &RunbookClient{
-		client:    client,
-		baseUrl:   baseUrl,
-		authToken: authToken,
+		client:     client,
+		baseUrl:    baseUrl,
+		authToken:  authToken,
+		retries:    RunbookRetries,
+		retryDelay: RunbookRetryDelay,
 	}, nil
 }
 
@@ -63,7 +162,16 @@ func CreateRunbookClientWithEnvConfig() (*RunbookClient, error) {
 }
 
 /**
- * @brief      Perform an API request
+ * @brief      Creates a runbook client that can not reach the runbook API,
+ *             queueing the checklist item updates in the given outbox file
+ */
+func CreateOfflineRunbookClient(outbox string) *RunbookClient {
+	return &RunbookClient{outbox: outbox}
+}
+
+/**
+ * @brief      Perform an API request, retrying it with an exponential
+ *             backoff if it fails with a transient error
  *
  * @param      verb     The HTTP method to use
  * @param      path     The path
@@ -74,9 +182,11 @@ func CreateRunbookClientWithEnvConfig() (*RunbookClient, error) {
  */
 func (c *RunbookClient) apiDo(verb string, path string, apiReq interface{}, apiResp interface{}) error {
 	var body []byte
-	var respBody apiResponse
 	var err error
 
+	if c.client == nil {
+		return fmt.Errorf("The runbook API is not available offline (%s %s)", verb, path)
+	}
 	if apiReq != nil {
 		body, err = json.Marshal(apiReq)
 		if err != nil {
@@ -84,6 +194,32 @@ func (c *RunbookClient) apiDo(verb string, path string, apiReq interface{}, apiR
 		}
 	}
 
+	delay := c.retryDelay
+	for attempt := 0; ; attempt++ {
+		err = c.apiDoOnce(verb, path, body, apiResp)
+		rerr, ok := err.(*RunbookError)
+		if !ok || !rerr.Transient() || attempt >= c.retries {
+			return err
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+/**
+ * @brief      Perform a single attempt of an API request
+ *
+ * @param      verb     The HTTP method to use
+ * @param      path     The path
+ * @param      body     The encoded request body
+ * @param      apiResp  The api response
+ *
+ * @return     Returns the error occurred or nil
+ */
+func (c *RunbookClient) apiDoOnce(verb string, path string, body []byte, apiResp interface{}) error {
+	var respBody apiResponse
+
 	url := fmt.Sprintf("%s%s", c.baseUrl, path)
 	req, err := http.NewRequest(verb, url, bytes.NewReader(body))
 	if err != nil {
@@ -99,17 +235,30 @@ func (c *RunbookClient) apiDo(verb string, path string, apiReq interface{}, apiR
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("Could not place request: %s", err.Error())
+		return &RunbookError{verb, path, fmt.Sprintf("Could not place request: %s", err.Error()), 0}
 	}
 	defer resp.Body.Close()
 
-	body, _ = ioutil.ReadAll(resp.Body)
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return &RunbookError{verb, path, fmt.Sprintf("Could not read response: %s", err.Error()), 0}
+	}
+
+	// Error responses might not be in the API format, for example when
+	// they come from a proxy
 	err = json.Unmarshal(body, &respBody)
+	if resp.StatusCode >= 400 {
+		message := http.StatusText(resp.StatusCode)
+		if err == nil && respBody.Error != "" {
+			message = respBody.Error
+		}
+		return &RunbookError{verb, path, fmt.Sprintf("Server replied with error: %s", message), resp.StatusCode}
+	}
 	if err != nil {
-		return fmt.Errorf("Could not parse response: %s", err.Error())
+		return &RunbookError{verb, path, fmt.Sprintf("Could not parse response: %s", err.Error()), resp.StatusCode}
 	}
 	if respBody.Status != "ok" {
-		return fmt.Errorf("Server replied with error: %s", respBody.Error)
+		return &RunbookError{verb, path, fmt.Sprintf("Server replied with error: %s", respBody.Error), resp.StatusCode}
 	}
 
 	if apiResp != nil {
@@ -135,7 +284,7 @@ func (c *RunbookClient) GetVariables(domain string) (map[string]string, error) {
 	}
 
 	// Get all the dynamic variables used in the operation
-	err := c.apiDo("GET", "/op/vars/global", nil, &varsResponse)
+	err := c.apiDo("GET", fmt.Sprintf("/op/vars/%s", domain), nil, &varsResponse)
 	if err != nil {
 		return nil, err
 	}
@@ -148,21 +297,58 @@ func (c *RunbookClient) GetVariables(domain string) (map[string]string, error) {
 	return vars, nil
 }
 
+/**
+ * @brief      Collect the variables visible to the given component, with the
+ *             component variables overriding the global ones and the local
+ *             variables overriding both
+ *
+ * @param      component  The component
+ * @param      local      The local variables of the checklist
+ *
+ * @return     Returns the merged variables
+ */
+func (c *RunbookClient) ScopedVariables(component string, local map[string]string) (map[string]string, error) {
+	vars := make(map[string]string)
+	domains := []string{"global"}
+	if component != "" && component != "global" {
+		domains = append(domains, component)
+	}
+
+	for _, domain := range domains {
+		domainVars, err := c.GetVariables(domain)
+		if err != nil {
+			// A domain without any variables is not an error
+			if rerr, ok := err.(*RunbookError); ok && rerr.StatusCode == http.StatusNotFound {
+				continue
+			}
+			return nil, fmt.Errorf("Could not get the %s variables: %s", domain, err.Error())
+		}
+		for k, v := range domainVars {
+			vars[k] = v
+		}
+	}
+
+	for k, v := range local {
+		vars[k] = v
+	}
+	return vars, nil
+}
+
 /**
  * @brief      Try to compose a set of commands to invoke by fetching the
  *             instructions from the runbook app.
  *
- * @param      component  The component
  * @param      step       The step
+ * @param      localVars  The local variables of the checklist, overriding
+ *                        the runbook variables
  *
  * @return     Returns
  */
-func (c *RunbookClient) ChecklistFromRunbook(step string) (Checklist, error) {
-	rxBlock := regexp.MustCompile(`\x60\x60\x60sh([\w\W]*)\x60\x60\x60`)
+func (c *RunbookClient) ChecklistFromRunbook(step string, localVars map[string]string) (Checklist, error) {
 	type RunbookChecklistItem struct {
-		Id     string `json:"id"`
-		Title  string `json:"title"`
-		Status int    `json:"status"`
+		Id     string        `json:"id"`
+		Title  string        `json:"title"`
+		Status RunbookStatus `json:"status"`
 	}
 	var checklist Checklist = nil
 	var checklists []RunbookChecklistItem
@@ -171,18 +357,14 @@ func (c *RunbookClient) ChecklistFromRunbook(step string) (Checklist, error) {
 		Instructions string `json:"instructions"`
 	}
 
-	// Get all the dynamic variables used in the operation
-	vars, err := c.GetVariables("global")
-
 	// Get the step info to get the instructions markdown
-	err = c.apiDo("GET", fmt.Sprintf("/step/%s", step), nil, &stepInfo)
+	err := c.apiDo("GET", fmt.Sprintf("/step/%s", step), nil, &stepInfo)
 	if err != nil {
 		return nil, err
 	}
 
-	// Get component-local variables
-	localVars, err := c.GetVariables(stepInfo.Component)
-	err = mergo.Merge(&vars, localVars)
+	// Get all the dynamic variables used in the operation
+	vars, err := c.ScopedVariables(stepInfo.Component, localVars)
 	if err != nil {
 		return nil, err
 	}
@@ -193,66 +375,81 @@ func (c *RunbookClient) ChecklistFromRunbook(step string) (Checklist, error) {
 		return nil, err
 	}
 
-	// Get all the items from the markdown in order to preserve the order
-	rx := regexp.MustCompile(`{!([^}]+)}`)
-	ids := rx.FindAllStringSubmatch(stepInfo.Instructions, -1)
-
-	var orderedChecklists []*RunbookChecklistItem
-	var found *RunbookChecklistItem = nil
-	for _, match := range ids {
-		found = nil
-		for _, item := range checklists {
-			// Don't include completed and skipped items
-			if item.Status == 1 || item.Status == 3 {
-				continue
-			}
-			if item.Id == match[1] {
-				found = &item
+	// Locate the section of every item in the markdown instructions, in
+	// order to preserve the order and extract the scripts
+	sections, warnings := markdownSections(stepInfo.Instructions)
+	referenced := make(map[string]bool)
+	var problems []string
+	for _, section := range sections {
+		referenced[section.id] = true
+
+		var found *RunbookChecklistItem = nil
+		for i, item := range checklists {
+			if item.Id == section.id {
+				found = &checklists[i]
 				break
 			}
 		}
 
-		if found != nil {
-			orderedChecklists = append(orderedChecklists, found)
-		}
-	}
-
-	// And for each step extract the respective markdown checklist item text
-	// from the instructions markdown
-	for _, item := range orderedChecklists {
-
-		rx := regexp.MustCompile(fmt.Sprintf(`{!%s}([\w\W]+?)([\r\n]\s*\*|$)`, item.Id))
-		parts := rx.FindStringSubmatch(stepInfo.Instructions)
-		if parts == nil {
+		// Don't include completed and skipped items
+		if found == nil || found.Status == RunbookCompleted || found.Status == RunbookSkipped {
 			continue
 		}
-
-		// On each markdown block, try to locate a shell script block
-		parts = rxBlock.FindStringSubmatch(parts[1])
-		if parts == nil {
+		if len(section.scripts) == 0 {
+			problems = append(problems, fmt.Sprintf("Item %s has no sh, bash or shell code block", found.Id))
 			continue
 		}
 
 		// Replace all variables
-		script := dedent.Dedent(parts[1])
-		for key, value := range vars {
-			script = strings.ReplaceAll(script, fmt.Sprintf("{{%s}}", key), value)
+		script, err := Interpolate(strings.Join(section.scripts, "\n"), vars)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("Item %s: %s", found.Id, err.Error()))
+			continue
+		}
+		expect, err := Interpolate(section.expect, vars)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("Item %s: %s", found.Id, err.Error()))
+			continue
 		}
 
 		// Collect checklist item
-		checklist = append(checklist, ChecklistItem{
-			Title:       item.Title,
+		item := ChecklistItem{
+			Title:       found.Title,
 			Script:      script,
-			RunbookID:   item.Id,
+			ExpectMatch: expect,
+			RunbookID:   found.Id,
 			RunbookStep: step,
-		})
+			Filename:    "runbook:" + step,
+		}
+		err = ValidateItem(&item)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("Item %s: %s", found.Id, err.Error()))
+			continue
+		}
+		checklist = append(checklist, item)
+	}
+
+	// The items without instructions are left for the operator to complete
+	for _, item := range checklists {
+		if !referenced[item.Id] && item.Status != RunbookCompleted && item.Status != RunbookSkipped {
+			warnings = append(warnings, fmt.Sprintf("Item %s is not referenced in the instructions and was not imported", item.Id))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("Invalid instructions in step %s:\n - %s", step, strings.Join(problems, "\n - "))
+	}
+	if c.Warn != nil {
+		for _, warning := range warnings {
+			c.Warn(fmt.Sprintf("Step %s: %s", step, warning))
+		}
 	}
 
 	return checklist, nil
 }
 
 /**
- * @brief      Update the checklist item with the given status
+ * @brief      Update the checklist item with the given status, or queue the
+ *             update in the outbox if the client has one
  *
  * @param      id       The identifier
  * @param      status   The state
@@ -260,14 +457,27 @@ func (c *RunbookClient) ChecklistFromRunbook(step string) (Checklist, error) {
  *
  * @return     Returns the failure if it happened
  */
-func (c *RunbookClient) ChecklistItemUpdate(stepId string, itemId string, status int, reason string) error {
+func (c *RunbookClient) ChecklistItemUpdate(stepId string, itemId string, status RunbookStatus, reason string) error {
+	if c.outbox != "" {
+		return QueueRunbookUpdate(c.outbox, RunbookQueuedUpdate{
+			Step:   stepId,
+			Item:   itemId,
+			Status: status,
+			Reason: MaskSecrets(reason),
+			Queued: time.Now(),
+		})
+	}
+	return c.sendItemUpdate(stepId, itemId, status, reason)
+}
+
+func (c *RunbookClient) sendItemUpdate(stepId string, itemId string, status RunbookStatus, reason string) error {
 	var updateItemStatus struct {
-		Status int    `json:"status"`
-		Reason string `json:"reason,omitempty"`
+		Status RunbookStatus `json:"status"`
+		Reason string        `json:"reason,omitempty"`
 	}
 
 	updateItemStatus.Status = status
-	updateItemStatus.Reason = reason
+	updateItemStatus.Reason = MaskSecrets(reason)
 
 	return c.apiDo("PATCH", fmt.Sprintf("/step/%s/checklist/%s", stepId, itemId), updateItemStatus, nil)
 }
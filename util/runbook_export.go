@@ -0,0 +1,60 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+/**
+ * The fields of an item imported from runbook that are written to an
+ * exported checklist
+ */
+type exportedItem struct {
+	Title       string `yaml:"title"`
+	Script      string `yaml:"script"`
+	ExpectMatch string `yaml:"expect,omitempty"`
+	RunbookID   string `yaml:"runbook_id"`
+	RunbookStep string `yaml:"runbook_step"`
+}
+
+type exportedChecklist struct {
+	Title     string         `yaml:"title"`
+	Provider  string         `yaml:"provider,omitempty"`
+	Checklist []exportedItem `yaml:"checklist"`
+}
+
+/**
+ * Writes the items imported from the given runbook steps as a standalone
+ * checklist file, that can be run without reaching the runbook API. The
+ * items keep their `runbook_id` and `runbook_step`, so their outcome can
+ * still be reported (or queued in an outbox).
+ */
+func WriteRunbookChecklist(w io.Writer, title string, provider string, steps []string, checklist Checklist) error {
+	exported := exportedChecklist{
+		Title:    title,
+		Provider: provider,
+	}
+	for _, item := range checklist {
+		exported.Checklist = append(exported.Checklist, exportedItem{
+			Title:       item.Title,
+			Script:      item.Script,
+			ExpectMatch: item.ExpectMatch,
+			RunbookID:   item.RunbookID,
+			RunbookStep: item.RunbookStep,
+		})
+	}
+
+	content, err := yaml.Marshal(&exported)
+	if err != nil {
+		return fmt.Errorf("Could not encode checklist: %s", err.Error())
+	}
+
+	header := fmt.Sprintf("# Exported from runbook step %s on %s\n",
+		strings.Join(steps, ", "), time.Now().Format(time.RFC3339))
+	_, err = io.WriteString(w, header+string(content))
+	return err
+}
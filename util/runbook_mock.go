@@ -0,0 +1,210 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+/**
+ * The contents served by the runbook mock server
+ */
+type RunbookFixture struct {
+	// The variables of every domain (`global` or a component name)
+	Vars  map[string]map[string]interface{} `yaml:"vars"`
+	Steps map[string]*RunbookFixtureStep    `yaml:"steps"`
+}
+
+/**
+ * A runbook step, with the markdown instructions and the checklist items
+ * referenced in them with `{!<id>}`
+ */
+type RunbookFixtureStep struct {
+	Component    string               `yaml:"component" json:"component"`
+	Instructions string               `yaml:"instructions" json:"instructions"`
+	Checklist    []RunbookFixtureItem `yaml:"checklist" json:"-"`
+}
+
+type RunbookFixtureItem struct {
+	ID     string        `yaml:"id" json:"id"`
+	Title  string        `yaml:"title" json:"title"`
+	Status RunbookStatus `yaml:"status" json:"status"`
+	Reason string        `yaml:"reason" json:"reason,omitempty"`
+}
+
+/**
+ * Loads a runbook fixture from the given YAML file
+ */
+func LoadRunbookFixture(filename string) (*RunbookFixture, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read %s: %s", filename, err.Error())
+	}
+
+	var fixture RunbookFixture
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	dec.KnownFields(true)
+	err = dec.Decode(&fixture)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("Could not parse %s: %s", filename, err.Error())
+	}
+	return &fixture, nil
+}
+
+/**
+ * An in-memory implementation of the runbook API, serving the contents of a
+ * fixture. It's an http.Handler, so it can be used with an http.Server or an
+ * httptest.Server.
+ */
+type RunbookMock struct {
+	// If set, requests must be authenticated with this token
+	Token string
+
+	// Called after every request with the method, the path and the status
+	// code of the response
+	OnRequest func(verb string, path string, status int)
+
+	fixture  *RunbookFixture
+	requests int
+	failNext int
+	lock     sync.Mutex
+}
+
+func CreateRunbookMock(fixture *RunbookFixture) *RunbookMock {
+	if fixture.Vars == nil {
+		fixture.Vars = make(map[string]map[string]interface{})
+	}
+	if fixture.Steps == nil {
+		fixture.Steps = make(map[string]*RunbookFixtureStep)
+	}
+	return &RunbookMock{fixture: fixture}
+}
+
+/**
+ * Fails the given number of upcoming requests with a 503 error, to simulate
+ * transient failures
+ */
+func (m *RunbookMock) FailRequests(count int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.failNext = count
+}
+
+/**
+ * Returns the number of requests served so far
+ */
+func (m *RunbookMock) Requests() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.requests
+}
+
+/**
+ * Returns the current state of the given checklist item
+ */
+func (m *RunbookMock) Item(stepId string, itemId string) (RunbookFixtureItem, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if step, ok := m.fixture.Steps[stepId]; ok {
+		for _, item := range step.Checklist {
+			if item.ID == itemId {
+				return item, true
+			}
+		}
+	}
+	return RunbookFixtureItem{}, false
+}
+
+func (m *RunbookMock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.requests++
+
+	status, data, err := m.route(r)
+	resp := apiResponse{Status: "ok"}
+	if err != nil {
+		resp.Status = "error"
+		resp.Error = err.Error()
+	} else {
+		resp.Data, _ = json.Marshal(data)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+
+	if m.OnRequest != nil {
+		m.OnRequest(r.Method, r.URL.Path, status)
+	}
+}
+
+/**
+ * Handles the given request, returning the status code and either the data
+ * or the error of the response
+ */
+func (m *RunbookMock) route(r *http.Request) (int, interface{}, error) {
+	if m.failNext > 0 {
+		m.failNext--
+		return http.StatusServiceUnavailable, nil, fmt.Errorf("Service temporarily unavailable")
+	}
+	if m.Token != "" && r.Header.Get("Authorization") != "token "+m.Token {
+		return http.StatusUnauthorized, nil, fmt.Errorf("Invalid authentication token")
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case len(parts) == 3 && parts[0] == "op" && parts[1] == "vars" && r.Method == "GET":
+		vars, ok := m.fixture.Vars[parts[2]]
+		if !ok {
+			vars = make(map[string]interface{})
+		}
+		return http.StatusOK, map[string]interface{}{"value": vars}, nil
+
+	case len(parts) >= 2 && parts[0] == "step":
+		step, ok := m.fixture.Steps[parts[1]]
+		if !ok {
+			return http.StatusNotFound, nil, fmt.Errorf("Step %s was not found", parts[1])
+		}
+
+		switch {
+		case len(parts) == 2 && r.Method == "GET":
+			return http.StatusOK, step, nil
+		case len(parts) == 3 && parts[2] == "checklist" && r.Method == "GET":
+			return http.StatusOK, step.Checklist, nil
+		case len(parts) == 4 && parts[2] == "checklist" && r.Method == "PATCH":
+			return m.updateItem(step, parts[3], r)
+		}
+	}
+
+	return http.StatusNotFound, nil, fmt.Errorf("No such endpoint: %s %s", r.Method, r.URL.Path)
+}
+
+func (m *RunbookMock) updateItem(step *RunbookFixtureStep, itemId string, r *http.Request) (int, interface{}, error) {
+	var update struct {
+		Status *RunbookStatus `json:"status"`
+		Reason string         `json:"reason"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&update)
+	if err != nil {
+		return http.StatusBadRequest, nil, fmt.Errorf("Could not parse request: %s", err.Error())
+	}
+	if update.Status == nil || *update.Status < RunbookPending || *update.Status > RunbookSkipped {
+		return http.StatusBadRequest, nil, fmt.Errorf("Missing or invalid status")
+	}
+
+	for i := range step.Checklist {
+		if step.Checklist[i].ID == itemId {
+			step.Checklist[i].Status = *update.Status
+			step.Checklist[i].Reason = update.Reason
+			return http.StatusOK, nil, nil
+		}
+	}
+	return http.StatusNotFound, nil, fmt.Errorf("Checklist item %s was not found", itemId)
+}
@@ -0,0 +1,153 @@
+package util
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+/**
+ * A checklist item update queued in an outbox file, to be sent to the
+ * runbook API later with `preflighter runbook sync`
+ */
+type RunbookQueuedUpdate struct {
+	Step   string        `json:"step"`
+	Item   string        `json:"item"`
+	Status RunbookStatus `json:"status"`
+	Reason string        `json:"reason,omitempty"`
+	Queued time.Time     `json:"queued"`
+}
+
+/**
+ * Appends the given update to the outbox file, one JSON object per line
+ */
+func QueueRunbookUpdate(filename string, update RunbookQueuedUpdate) error {
+	content, err := json.Marshal(update)
+	if err != nil {
+		return fmt.Errorf("Could not encode update: %s", err.Error())
+	}
+
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("Could not open outbox %s: %s", filename, err.Error())
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(content, '\n'))
+	if err != nil {
+		return fmt.Errorf("Could not write outbox %s: %s", filename, err.Error())
+	}
+	return nil
+}
+
+/**
+ * Loads the updates queued in the given outbox file, in the order they were
+ * queued. A missing outbox has no updates.
+ */
+func LoadRunbookOutbox(filename string) ([]RunbookQueuedUpdate, error) {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not open outbox %s: %s", filename, err.Error())
+	}
+	defer f.Close()
+
+	var updates []RunbookQueuedUpdate
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var update RunbookQueuedUpdate
+		err = json.Unmarshal([]byte(text), &update)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse line %d of outbox %s: %s", line, filename, err.Error())
+		}
+		updates = append(updates, update)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Could not read outbox %s: %s", filename, err.Error())
+	}
+	return updates, nil
+}
+
+/**
+ * Replaces the contents of the outbox file with the given updates, removing
+ * the file if there are none left
+ */
+func SaveRunbookOutbox(filename string, updates []RunbookQueuedUpdate) error {
+	if len(updates) == 0 {
+		err := os.Remove(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Could not remove outbox %s: %s", filename, err.Error())
+		}
+		return nil
+	}
+
+	var content []byte
+	for _, update := range updates {
+		line, err := json.Marshal(update)
+		if err != nil {
+			return fmt.Errorf("Could not encode update: %s", err.Error())
+		}
+		content = append(content, line...)
+		content = append(content, '\n')
+	}
+
+	tmpFile := filename + ".tmp"
+	err := ioutil.WriteFile(tmpFile, content, 0600)
+	if err != nil {
+		return fmt.Errorf("Could not write outbox %s: %s", filename, err.Error())
+	}
+	return os.Rename(tmpFile, filename)
+}
+
+/**
+ * Makes the client queue the checklist item updates in the given outbox file
+ * instead of sending them to the runbook API
+ */
+func (c *RunbookClient) QueueUpdates(outbox string) {
+	c.outbox = outbox
+}
+
+/**
+ * Sends the updates queued in the given outbox file to the runbook API, in
+ * the order they were queued. The `report` callback is called with the
+ * outcome of every update. The updates that failed are kept in the outbox,
+ * and all the remaining ones are kept as soon as the API is unreachable.
+ * Returns the number of updates sent and the number left in the outbox.
+ */
+func (c *RunbookClient) SyncOutbox(outbox string, report func(update *RunbookQueuedUpdate, err error)) (int, int, error) {
+	updates, err := LoadRunbookOutbox(outbox)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var pending []RunbookQueuedUpdate
+	sent := 0
+	for i := range updates {
+		update := &updates[i]
+		err := c.sendItemUpdate(update.Step, update.Item, update.Status, update.Reason)
+		report(update, err)
+		if err == nil {
+			sent += 1
+			continue
+		}
+
+		pending = append(pending, *update)
+		if rerr, ok := err.(*RunbookError); ok && rerr.Transient() {
+			pending = append(pending, updates[i+1:]...)
+			break
+		}
+	}
+
+	return sent, len(pending), SaveRunbookOutbox(outbox, pending)
+}
@@ -0,0 +1,372 @@
+package util
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testRunbookToken = "test-token"
+
+func testRunbookFixture() *RunbookFixture {
+	return &RunbookFixture{
+		Vars: map[string]map[string]interface{}{
+			"global":   {"cluster": "mwt42", "owner": "ops"},
+			"frontend": {"replicas": 3, "owner": "web"},
+		},
+		Steps: map[string]*RunbookFixtureStep{
+			"frontend.update": {
+				Component: "frontend",
+				Instructions: strings.Join([]string{
+					"* {!check-cluster} The cluster is the expected one:",
+					"  ```sh",
+					"  dcos config show core.dcos_url | grep {{cluster}}",
+					"  ```",
+					"* {!check-replicas} There are enough replicas:",
+					"  ```sh",
+					"  echo {{ replicas }} {{owner | upper}}",
+					"  ```",
+					"  ```expect",
+					"  ^{{replicas}} ",
+					"  ```",
+					"* {!announce} The update was announced",
+				}, "\n"),
+				Checklist: []RunbookFixtureItem{
+					{ID: "check-replicas", Title: "Are there enough replicas?", Status: RunbookPending},
+					{ID: "check-cluster", Title: "Is this the right cluster?", Status: RunbookFailed},
+					{ID: "announce", Title: "Was the update announced?", Status: RunbookCompleted},
+				},
+			},
+			"frontend.undefined": {
+				Component:    "frontend",
+				Instructions: "* {!item} Item\n  ```sh\n  echo {{missing}}\n  ```\n",
+				Checklist: []RunbookFixtureItem{
+					{ID: "item", Title: "Item", Status: RunbookPending},
+				},
+			},
+			"frontend.manual": {
+				Component:    "frontend",
+				Instructions: "* {!item} Item\n  ```sh\n  true\n  ```\n* Ask the team lead\n",
+				Checklist: []RunbookFixtureItem{
+					{ID: "item", Title: "Item", Status: RunbookPending},
+					{ID: "ask", Title: "Did the team lead agree?", Status: RunbookPending},
+					{ID: "done", Title: "Already done", Status: RunbookCompleted},
+				},
+			},
+		},
+	}
+}
+
+/**
+ * Serves the given fixture, returning the mock, a client using it and the
+ * function stopping the server
+ */
+func startTestRunbook(t *testing.T, fixture *RunbookFixture) (*RunbookMock, *RunbookClient, func()) {
+	mock := CreateRunbookMock(fixture)
+	mock.Token = testRunbookToken
+	server := httptest.NewServer(mock)
+
+	client, err := CreateRunbookClient(server.URL, testRunbookToken)
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	client.retryDelay = time.Millisecond
+	return mock, client, server.Close
+}
+
+/**
+ * Checks that the error is a RunbookError with the given status code and
+ * transient flag
+ */
+func expectRunbookError(t *testing.T, err error, status int, transient bool) {
+	t.Helper()
+	rerr, ok := err.(*RunbookError)
+	if !ok {
+		t.Fatalf("Expecting a RunbookError, got: %v", err)
+	}
+	if rerr.StatusCode != status {
+		t.Errorf("Expecting status code %d, got %d", status, rerr.StatusCode)
+	}
+	if rerr.Transient() != transient {
+		t.Errorf("Expecting the error to be transient=%v", transient)
+	}
+}
+
+func TestRunbookMissingStep(t *testing.T) {
+	mock, client, stop := startTestRunbook(t, testRunbookFixture())
+	defer stop()
+	err := client.apiDo("GET", "/step/--missing--", nil, nil)
+	expectRunbookError(t, err, http.StatusNotFound, false)
+	if n := mock.Requests(); n != 1 {
+		t.Errorf("Expecting no retries, got %d requests", n)
+	}
+}
+
+func TestRunbookInvalidToken(t *testing.T) {
+	mock, client, stop := startTestRunbook(t, testRunbookFixture())
+	defer stop()
+	client.authToken = "invalid-token"
+	err := client.apiDo("GET", "/op/vars/global", nil, nil)
+	expectRunbookError(t, err, http.StatusUnauthorized, false)
+	if n := mock.Requests(); n != 1 {
+		t.Errorf("Expecting no retries, got %d requests", n)
+	}
+}
+
+func TestRunbookRetriesTransientFailures(t *testing.T) {
+	mock, client, stop := startTestRunbook(t, testRunbookFixture())
+	defer stop()
+	mock.FailRequests(2)
+	err := client.apiDo("GET", "/op/vars/global", nil, nil)
+	if err != nil {
+		t.Fatalf("Expecting the request to succeed, got: %s", err.Error())
+	}
+	if n := mock.Requests(); n != 3 {
+		t.Errorf("Expecting 3 requests, got %d", n)
+	}
+}
+
+func TestRunbookRetryBackoff(t *testing.T) {
+	// Fails with every kind of transient error before succeeding, recording
+	// when the requests were received
+	failures := []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusInternalServerError}
+	var received []time.Time
+	var lock sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		received = append(received, time.Now())
+		if len(received) <= len(failures) {
+			w.WriteHeader(failures[len(received)-1])
+			return
+		}
+		io.WriteString(w, `{"status": "ok", "data": {"value": "up"}}`)
+	}))
+	defer server.Close()
+
+	client, err := CreateRunbookClient(server.URL, testRunbookToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.retryDelay = 20 * time.Millisecond
+
+	var resp struct {
+		Value string `json:"value"`
+	}
+	err = client.apiDo("GET", "/status", nil, &resp)
+	if err != nil || resp.Value != "up" {
+		t.Fatalf("Expecting the request to succeed after the failures, got %+v (%v)", resp, err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(received) != len(failures)+1 {
+		t.Fatalf("Expecting %d requests, got %d", len(failures)+1, len(received))
+	}
+
+	// The delay doubles after every failure
+	delay := client.retryDelay
+	for i := 1; i < len(received); i++ {
+		if waited := received[i].Sub(received[i-1]); waited < delay {
+			t.Errorf("Expecting retry %d after at least %s, got %s", i, delay, waited)
+		}
+		delay *= 2
+	}
+}
+
+func TestRunbookGivesUpAfterRetries(t *testing.T) {
+	mock, client, stop := startTestRunbook(t, testRunbookFixture())
+	defer stop()
+	mock.FailRequests(client.retries + 1)
+	err := client.apiDo("GET", "/op/vars/global", nil, nil)
+	expectRunbookError(t, err, http.StatusServiceUnavailable, true)
+	if n := mock.Requests(); n != client.retries+1 {
+		t.Errorf("Expecting %d requests, got %d", client.retries+1, n)
+	}
+}
+
+func TestRunbookDecodesStep(t *testing.T) {
+	fixture := testRunbookFixture()
+	_, client, stop := startTestRunbook(t, fixture)
+	defer stop()
+
+	var info struct {
+		Component    string `json:"component"`
+		Instructions string `json:"instructions"`
+	}
+	err := client.apiDo("GET", "/step/frontend.update", nil, &info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := fixture.Steps["frontend.update"]
+	if info.Component != step.Component || info.Instructions != step.Instructions {
+		t.Errorf("The decoded step does not match the fixture: %+v", info)
+	}
+}
+
+func TestRunbookScopedVariables(t *testing.T) {
+	_, client, stop := startTestRunbook(t, testRunbookFixture())
+	defer stop()
+	vars, err := client.ScopedVariables("frontend", map[string]string{"cluster": "local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{"cluster": "local", "owner": "web", "replicas": "3"}
+	for name, value := range expected {
+		if vars[name] != value {
+			t.Errorf("Expecting %s=%s, got %s", name, value, vars[name])
+		}
+	}
+}
+
+func TestRunbookChecklistFromRunbook(t *testing.T) {
+	_, client, stop := startTestRunbook(t, testRunbookFixture())
+	defer stop()
+	checklist, err := client.ChecklistFromRunbook("frontend.update", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// In the order of the instructions, without the completed items
+	if len(checklist) != 2 {
+		t.Fatalf("Expecting 2 items, got %d", len(checklist))
+	}
+	expected := []struct {
+		id, title, script, expect string
+	}{
+		{"check-cluster", "Is this the right cluster?", "dcos config show core.dcos_url | grep mwt42", ""},
+		{"check-replicas", "Are there enough replicas?", "echo 3 WEB", "^3"},
+	}
+	for i, e := range expected {
+		item := checklist[i]
+		if item.RunbookID != e.id || item.RunbookStep != "frontend.update" || item.Title != e.title {
+			t.Errorf("Item %d was not imported correctly: %+v", i, item)
+		}
+		if item.Script != e.script {
+			t.Errorf("Expecting the script of %s to be %q, got %q", e.id, e.script, item.Script)
+		}
+		if item.ExpectMatch != e.expect {
+			t.Errorf("Expecting %s to expect %q, got %q", e.id, e.expect, item.ExpectMatch)
+		}
+	}
+}
+
+func TestRunbookUndefinedVariable(t *testing.T) {
+	_, client, stop := startTestRunbook(t, testRunbookFixture())
+	defer stop()
+	_, err := client.ChecklistFromRunbook("frontend.undefined", nil)
+	if err == nil || !strings.Contains(err.Error(), "Undefined variable 'missing'") {
+		t.Errorf("Expecting an undefined variable error, got: %v", err)
+	}
+
+	_, err = client.ChecklistFromRunbook("frontend.undefined", map[string]string{"missing": "x"})
+	if err != nil {
+		t.Errorf("Expecting the local variable to be used, got: %s", err.Error())
+	}
+}
+
+func TestRunbookUnreferencedItem(t *testing.T) {
+	_, client, stop := startTestRunbook(t, testRunbookFixture())
+	defer stop()
+	var warnings []string
+	client.Warn = func(message string) {
+		warnings = append(warnings, message)
+	}
+
+	checklist, err := client.ChecklistFromRunbook("frontend.manual", nil)
+	if err != nil {
+		t.Fatalf("Expecting the referenced items to be imported, got: %s", err.Error())
+	}
+	if len(checklist) != 1 || checklist[0].RunbookID != "item" {
+		t.Errorf("Expecting only the referenced item, got %+v", checklist)
+	}
+	expected := "Step frontend.manual: Item ask is not referenced in the instructions and was not imported"
+	if len(warnings) != 1 || warnings[0] != expected {
+		t.Errorf("Expecting the warning %q, got %q", expected, warnings)
+	}
+}
+
+func TestRunbookChecklistItemUpdate(t *testing.T) {
+	mock, client, stop := startTestRunbook(t, testRunbookFixture())
+	defer stop()
+	for _, status := range []RunbookStatus{RunbookFailed, RunbookSkipped, RunbookCompleted} {
+		reason := "reason: " + status.String()
+		err := client.ChecklistItemUpdate("frontend.update", "check-replicas", status, reason)
+		if err != nil {
+			t.Fatal(err)
+		}
+		item, _ := mock.Item("frontend.update", "check-replicas")
+		if item.Status != status || item.Reason != reason {
+			t.Errorf("Expecting status %s, got %s", status, item.Status)
+		}
+	}
+
+	err := client.ChecklistItemUpdate("frontend.update", "--missing--", RunbookCompleted, "")
+	expectRunbookError(t, err, http.StatusNotFound, false)
+}
+
+func TestRunbookSyncOutbox(t *testing.T) {
+	mock, client, stop := startTestRunbook(t, testRunbookFixture())
+	defer stop()
+	dir, remove := writeTestFiles(t, nil)
+	defer remove()
+	outbox := filepath.Join(dir, "outbox")
+
+	offline := CreateOfflineRunbookClient(outbox)
+	offline.QueueUpdates(outbox)
+	for _, update := range []RunbookQueuedUpdate{
+		{Step: "frontend.update", Item: "check-replicas", Status: RunbookFailed, Reason: "first"},
+		{Step: "frontend.update", Item: "--missing--", Status: RunbookCompleted},
+		{Step: "frontend.update", Item: "check-replicas", Status: RunbookCompleted},
+	} {
+		err := offline.ChecklistItemUpdate(update.Step, update.Item, update.Status, update.Reason)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if mock.Requests() != 0 {
+		t.Fatalf("Expecting the updates to be queued")
+	}
+
+	// The API is unreachable: everything is kept
+	mock.FailRequests(client.retries + 1)
+	sent, pending, err := client.SyncOutbox(outbox, func(*RunbookQueuedUpdate, error) {})
+	if err != nil || sent != 0 || pending != 3 {
+		t.Fatalf("Expecting 0 sent and 3 pending, got %d, %d (%v)", sent, pending, err)
+	}
+
+	// The failed update is kept, the others are sent in order
+	sent, pending, err = client.SyncOutbox(outbox, func(*RunbookQueuedUpdate, error) {})
+	if err != nil || sent != 2 || pending != 1 {
+		t.Fatalf("Expecting 2 sent and 1 pending, got %d, %d (%v)", sent, pending, err)
+	}
+	item, _ := mock.Item("frontend.update", "check-replicas")
+	if item.Status != RunbookCompleted {
+		t.Errorf("Expecting the last update to win, got %s", item.Status)
+	}
+	left, _ := LoadRunbookOutbox(outbox)
+	if len(left) != 1 || left[0].Item != "--missing--" {
+		t.Errorf("Expecting the failed update to be kept, got %+v", left)
+	}
+}
+
+func TestRunbookExampleFixture(t *testing.T) {
+	fixture, err := LoadRunbookFixture(filepath.Join("..", "example", "runbook-fixture.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, client, stop := startTestRunbook(t, fixture)
+	defer stop()
+	for id := range fixture.Steps {
+		_, err := client.ChecklistFromRunbook(id, nil)
+		if err != nil {
+			t.Errorf("Could not import step %s: %s", id, err.Error())
+		}
+	}
+}
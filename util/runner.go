@@ -7,11 +7,43 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
+/**
+ * The error returned when a script did not complete within the time allowed
+ */
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("Timed out after %s", e.Timeout)
+}
+
+/**
+ * A Runner is safe for concurrent use, as long as its configuration is not
+ * modified while scripts are running
+ */
 type Runner struct {
-	CacheDir       string
-	Config         *Config
+	CacheDir string
+	Config   *Config
+}
+
+/**
+ * Per-execution options of a script
+ */
+type RunOptions struct {
+	// Exposed to the script in the $VALUE environment variable
+	Value string
+
+	// Kill the script if it does not complete within this time (0 = forever)
+	Timeout time.Duration
+
+	// Called for every line the script writes on stderr
 	StderrCallback func(string)
 }
 
@@ -30,9 +62,8 @@ func CreateRunner(c *Config) (*Runner, error) {
 	}
 
 	return &Runner{
-		CacheDir:       dir,
-		Config:         c,
-		StderrCallback: nil,
+		CacheDir: dir,
+		Config:   c,
 	}, nil
 }
 
@@ -51,13 +82,11 @@ func (r *Runner) GetMissingTools() []string {
 		"awk",
 		"bash",
 		"cat",
-		"curl",
-		"dcos",
-		"jq",
 		"shasum",
 		"tr",
 	}
 
+	tools = append(tools, r.Config.ProviderTools...)
 	tools = append(tools, r.Config.UserTools...)
 
 	for _, tool := range tools {
@@ -81,8 +110,20 @@ func (r *Runner) Run(script string) (string, string, error) {
  * Execute the given script and collect stdout/stderr
  */
 func (r *Runner) RunWithValue(script string, value string) (string, string, error) {
+	return r.RunWithOptions(script, RunOptions{Value: value})
+}
+
+/**
+ * Execute the given script and collect stdout/stderr, killing the entire
+ * process group if it does not complete within the timeout in the options.
+ */
+func (r *Runner) RunWithOptions(script string, opts RunOptions) (string, string, error) {
 	cmd := exec.Command("bash")
 
+	// Run in a process group of its own, so we can kill all the children
+	// that the script has spawned when the timeout expires
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
 	// Open I/O pipes
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -100,17 +141,31 @@ func (r *Runner) RunWithValue(script string, value string) (string, string, erro
 	// Prepare environment
 	list := r.Config.GetEnvList()
 	list = append(list, fmt.Sprintf("CACHE_DIR=%s", r.CacheDir))
-	if value != "" {
-		list = append(list, fmt.Sprintf("VALUE=%s", value))
+	if opts.Value != "" {
+		list = append(list, fmt.Sprintf("VALUE=%s", opts.Value))
 	}
 	cmd.Env = append(os.Environ(), list...)
 
+	// Make sure the process group is killed if we are interrupted, since
+	// it does not get the signals of the terminal
+	installSignalHandler()
 	err = cmd.Start()
 	if err != nil {
 		return "", "", fmt.Errorf("Unable to start process: %s", err.Error())
 	}
+	trackProcessGroup(cmd.Process.Pid, true)
+	defer trackProcessGroup(cmd.Process.Pid, false)
+
+	var timedOut int32 = 0
+	if opts.Timeout > 0 {
+		timer := time.AfterFunc(opts.Timeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		})
+		defer timer.Stop()
+	}
 
-	io.WriteString(stdin, fmt.Sprintf("%s\n%s\n%s", BashLibrary, r.Config.UserLib, script))
+	io.WriteString(stdin, fmt.Sprintf("%s\n%s\n%s\n%s", BashLibrary, r.Config.ProviderLib, r.Config.UserLib, script))
 	stdin.Close()
 
 	sserr := ""
@@ -118,8 +173,8 @@ func (r *Runner) RunWithValue(script string, value string) (string, string, erro
 	for scanner.Scan() {
 		line := scanner.Text()
 		sserr += line + "\n"
-		if r.StderrCallback != nil {
-			r.StderrCallback(line)
+		if opts.StderrCallback != nil {
+			opts.StderrCallback(line)
 		}
 	}
 	stderr.Close()
@@ -131,6 +186,9 @@ func (r *Runner) RunWithValue(script string, value string) (string, string, erro
 	stdout.Close()
 
 	err = cmd.Wait()
+	if atomic.LoadInt32(&timedOut) != 0 {
+		return string(ssout), sserr, &TimeoutError{opts.Timeout}
+	}
 	if err != nil {
 		if xerr, ok := err.(*exec.ExitError); ok {
 			return string(ssout), string(sserr), xerr
@@ -140,3 +198,43 @@ func (r *Runner) RunWithValue(script string, value string) (string, string, erro
 
 	return string(ssout), sserr, nil
 }
+
+// The process groups of the scripts currently running
+var runningGroups = make(map[int]bool)
+var runningGroupsLock sync.Mutex
+
+func trackProcessGroup(pgid int, running bool) {
+	runningGroupsLock.Lock()
+	defer runningGroupsLock.Unlock()
+	if running {
+		runningGroups[pgid] = true
+	} else {
+		delete(runningGroups, pgid)
+	}
+}
+
+/**
+ * Terminates the process groups of all the running scripts, killing the
+ * ones that are still alive after the given grace period
+ */
+func killRunningScripts(grace time.Duration) {
+	runningGroupsLock.Lock()
+	var groups []int
+	for pgid := range runningGroups {
+		groups = append(groups, pgid)
+	}
+	runningGroupsLock.Unlock()
+
+	for _, pgid := range groups {
+		syscall.Kill(-pgid, syscall.SIGTERM)
+	}
+
+	deadline := time.Now().Add(grace)
+	for _, pgid := range groups {
+		// Signal 0 checks if any process of the group is still alive
+		for syscall.Kill(-pgid, 0) == nil && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+		syscall.Kill(-pgid, syscall.SIGKILL)
+	}
+}
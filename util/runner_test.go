@@ -0,0 +1,53 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunnerTimeoutKillsChildren(t *testing.T) {
+	runner, cleanup := createTestRunner(t)
+	defer cleanup()
+	dir, remove := writeTestFiles(t, nil)
+	defer remove()
+	marker := filepath.Join(dir, "marker")
+
+	// The child outlives the script unless the whole process group is killed,
+	// and keeps the output pipes open until then
+	started := time.Now()
+	_, _, err := runner.RunWithOptions("(sleep 0.5; touch "+marker+") & sleep 30", RunOptions{Timeout: 200 * time.Millisecond})
+	if !IsTimeout(err) {
+		t.Fatalf("Expecting a timeout, got: %v", err)
+	}
+	if elapsed := time.Now().Sub(started); elapsed > 5*time.Second {
+		t.Errorf("Expecting the script to be killed on time, took %s", elapsed)
+	}
+
+	time.Sleep(time.Second)
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("Expecting the children of the script to be killed")
+	}
+}
+
+func TestRunItemScriptTimeout(t *testing.T) {
+	runner, cleanup := createTestRunner(t)
+	defer cleanup()
+
+	item := ChecklistItem{Title: "Hung", Script: "echo partial; sleep 30", Timeout: Duration(200 * time.Millisecond)}
+	res, err := RunItemScript(&item, runner, nil)
+	if !IsTimeout(err) || !res.TimedOut || res.ExitCode != -1 {
+		t.Errorf("Expecting the item to time out, got %+v (%v)", res, err)
+	}
+	if res.Stdout != "partial" {
+		t.Errorf("Expecting the output before the timeout, got %q", res.Stdout)
+	}
+
+	item.Timeout = 0
+	item.Script = "sleep 0.3; echo done"
+	res, err = RunItemScript(&item, runner, nil)
+	if err != nil || res.Stdout != "done" {
+		t.Errorf("Expecting no timeout by default, got %+v (%v)", res, err)
+	}
+}
@@ -0,0 +1,83 @@
+package util
+
+/**
+ * The result of the automatic checks of an item, as produced by the scheduler
+ */
+type ItemCheck struct {
+	Result CheckResult
+	Err    error
+}
+
+/**
+ * Runs the automatic checks of the given items using up to `jobs` concurrent
+ * workers.
+ *
+ * Items without automatic checks are resolved with the `undecided` outcome
+ * (see AutoCheckItem). An item is started only after all the items it
+ * depends on have completed, and it's not run at all (reported as blocked)
+ * if any of them has failed. The `handle` callback is invoked for every item
+ * in the original order, as soon as the item and all the items before it
+ * have completed.
+ */
+func RunItemChecks(items []ChecklistItem, runner *Runner, jobs int, undecided Outcome, handle func(idx int, check *ItemCheck)) {
+	type completion struct {
+		idx   int
+		check ItemCheck
+	}
+
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	graph := CreateDependencyGraph(items)
+	outcomes := make([]Outcome, len(items))
+	checks := make([]*ItemCheck, len(items))
+	started := make([]bool, len(items))
+	ch := make(chan completion)
+	running := 0
+
+	// Returns true if the item can be resolved now
+	ready := func(idx int) bool {
+		for _, dep := range graph.Dependencies(idx) {
+			if checks[dep] == nil {
+				return false
+			}
+		}
+		return true
+	}
+
+	for display := 0; display < len(items); {
+		// Start every item that has its dependencies resolved, while
+		// keeping the number of running workers under the limit
+		for idx := display; idx < len(items) && running < jobs; idx++ {
+			if started[idx] || !ready(idx) {
+				continue
+			}
+			started[idx] = true
+
+			if dep := graph.BlockedBy(idx, outcomes); dep >= 0 {
+				outcomes[idx] = OutcomeBlocked
+				checks[idx] = &ItemCheck{Result: CheckResult{Outcome: OutcomeBlocked}}
+				idx = display - 1 // Re-scan for items unblocked by this one
+				continue
+			}
+
+			go func(idx int) {
+				res, err := AutoCheckItem(&items[idx], runner, undecided)
+				ch <- completion{idx, ItemCheck{res, err}}
+			}(idx)
+			running++
+		}
+
+		if checks[display] != nil {
+			handle(display, checks[display])
+			display++
+			continue
+		}
+
+		c := <-ch
+		running--
+		outcomes[c.idx] = c.check.Result.Outcome
+		checks[c.idx] = &c.check
+	}
+}
@@ -0,0 +1,120 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+/**
+ * Runs the checks of the given items, returning the indices in the order they
+ * were handled and their outcomes
+ */
+func runTestItemChecks(t *testing.T, items []ChecklistItem, jobs int) ([]int, []Outcome) {
+	t.Helper()
+	runner, cleanup := createTestRunner(t)
+	defer cleanup()
+
+	var order []int
+	outcomes := make([]Outcome, len(items))
+	RunItemChecks(items, runner, jobs, OutcomeNoChecks, func(idx int, check *ItemCheck) {
+		order = append(order, idx)
+		outcomes[idx] = check.Result.Outcome
+	})
+	return order, outcomes
+}
+
+func TestRunItemChecksOrder(t *testing.T) {
+	dir, remove := writeTestFiles(t, nil)
+	defer remove()
+	log := filepath.Join(dir, "log")
+
+	// The first item is the slowest, so it completes last when run in parallel
+	items := []ChecklistItem{
+		{Title: "Slow", Script: "sleep 0.3; echo slow >> " + log, ExpectExit: ExitCodes{0}},
+		{Title: "Fast", Script: "echo fast >> " + log, ExpectExit: ExitCodes{0}},
+	}
+	for _, c := range []struct {
+		jobs      int
+		completed string
+	}{
+		{1, "slow\nfast\n"},
+		{2, "fast\nslow\n"},
+	} {
+		os.Remove(log)
+		order, outcomes := runTestItemChecks(t, items, c.jobs)
+
+		// The items are always handled in their original order
+		if !reflect.DeepEqual(order, []int{0, 1}) {
+			t.Errorf("Expecting the items to be handled in order with -j %d, got %v", c.jobs, order)
+		}
+		for idx, outcome := range outcomes {
+			if outcome != OutcomePass {
+				t.Errorf("Expecting item %d to pass with -j %d, got %s", idx, c.jobs, outcome)
+			}
+		}
+
+		completed, _ := ioutil.ReadFile(log)
+		if string(completed) != c.completed {
+			t.Errorf("Expecting the items to complete in the order %q with -j %d, got %q", c.completed, c.jobs, completed)
+		}
+	}
+}
+
+func TestRunItemChecksBlockedChain(t *testing.T) {
+	dir, remove := writeTestFiles(t, nil)
+	defer remove()
+	ran := filepath.Join(dir, "ran")
+
+	items := []ChecklistItem{
+		{ID: "reachable", Title: "Reachable", Script: "false", ExpectExit: ExitCodes{0}},
+		{ID: "login", Title: "Login", Script: "touch " + ran, ExpectExit: ExitCodes{0}, DependsOn: []string{"reachable"}},
+		{ID: "deploy", Title: "Deploy", Script: "touch " + ran, ExpectExit: ExitCodes{0}, DependsOn: []string{"login"}},
+		{ID: "disk", Title: "Disk", Script: "true", ExpectExit: ExitCodes{0}},
+	}
+	for _, jobs := range []int{1, 4} {
+		order, outcomes := runTestItemChecks(t, items, jobs)
+		if !reflect.DeepEqual(order, []int{0, 1, 2, 3}) {
+			t.Errorf("Expecting the items to be handled in order with -j %d, got %v", jobs, order)
+		}
+
+		// The failure blocks the whole chain, but not the independent item
+		expected := []Outcome{OutcomeFail, OutcomeBlocked, OutcomeBlocked, OutcomePass}
+		if !reflect.DeepEqual(outcomes, expected) {
+			t.Errorf("Expecting the outcomes %v with -j %d, got %v", expected, jobs, outcomes)
+		}
+		if _, err := os.Stat(ran); err == nil {
+			t.Errorf("Expecting the blocked items not to run with -j %d", jobs)
+		}
+	}
+}
+
+func TestSortChecklistCycle(t *testing.T) {
+	items := Checklist{
+		{ID: "disk", Title: "Disk", Script: "true"},
+		{ID: "a", Title: "A", Script: "true", DependsOn: []string{"c"}},
+		{ID: "b", Title: "B", Script: "true", DependsOn: []string{"a"}},
+		{ID: "c", Title: "C", Script: "true", DependsOn: []string{"b", "disk"}},
+	}
+	_, err := SortChecklist(items)
+	if err == nil || !strings.Contains(err.Error(), "Dependency cycle: a -> c -> b -> a") {
+		t.Errorf("Expecting a dependency cycle error, got: %v", err)
+	}
+
+	// Once the cycle is broken, the items come after their dependencies
+	items[3].DependsOn = []string{"disk"}
+	sorted, err := SortChecklist(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, item := range sorted {
+		ids = append(ids, item.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"disk", "c", "a", "b"}) {
+		t.Errorf("Expecting the items in dependency order, got %v", ids)
+	}
+}
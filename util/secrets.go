@@ -0,0 +1,53 @@
+package util
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The text displayed in place of a secret value
+const SecretMask = "******"
+
+// Values shorter than this are not masked, since they would mask too much
+// unrelated text
+const minSecretLength = 4
+
+var secretValues []string
+var secretsLock sync.RWMutex
+
+/**
+ * Registers a value that must never be displayed, logged or reported
+ */
+func AddSecret(value string) {
+	value = strings.TrimSpace(value)
+	if len(value) < minSecretLength {
+		return
+	}
+
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+	for _, existing := range secretValues {
+		if existing == value {
+			return
+		}
+	}
+
+	// Longer values are masked first, in case a secret contains another
+	secretValues = append(secretValues, value)
+	sort.Slice(secretValues, func(i, j int) bool {
+		return len(secretValues[i]) > len(secretValues[j])
+	})
+}
+
+/**
+ * Replaces all the registered secret values in the given text
+ */
+func MaskSecrets(text string) string {
+	secretsLock.RLock()
+	defer secretsLock.RUnlock()
+	for _, value := range secretValues {
+		text = strings.Replace(text, value, SecretMask, -1)
+	}
+	return text
+}
@@ -0,0 +1,218 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+/**
+ * The persisted progress of a checklist run, used to resume an interrupted
+ * session
+ */
+type Session struct {
+	Checksum string        `json:"checksum"`
+	Updated  time.Time     `json:"updated"`
+	Items    []SessionItem `json:"items"`
+	filename string
+}
+
+/**
+ * The persisted outcome of a single item
+ */
+type SessionItem struct {
+	Title   string `json:"title"`
+	Outcome string `json:"outcome,omitempty"`
+	Value   string `json:"value,omitempty"`
+}
+
+/**
+ * The content of an item identifying it in a session. The file it came from
+ * and its timeout are left out, so that a session can be resumed from another
+ * working directory or with another `-timeout`.
+ */
+type sessionItemContent struct {
+	ID            string          `json:"id"`
+	Title         string          `json:"title"`
+	Script        string          `json:"script"`
+	ExpectMatch   string          `json:"expect"`
+	ExpectScript  string          `json:"expect_script"`
+	NotMatch      string          `json:"not_match"`
+	ExpectNumber  string          `json:"expect_number"`
+	ExpectVersion string          `json:"expect_version"`
+	ExpectOneOf   []string        `json:"expect_one_of"`
+	ExpectJSON    []JSONAssertion `json:"expect_json"`
+	ExpectExit    ExitCodes       `json:"expect_exit"`
+	ExpectStderr  string          `json:"expect_stderr"`
+}
+
+/**
+ * Computes a checksum of the given items, used to identify the session
+ */
+func ChecklistChecksum(items []ChecklistItem) string {
+	content := make([]sessionItemContent, len(items))
+	for i, item := range items {
+		content[i] = sessionItemContent{
+			ID:            item.ID,
+			Title:         item.Title,
+			Script:        item.Script,
+			ExpectMatch:   item.ExpectMatch,
+			ExpectScript:  item.ExpectScript,
+			NotMatch:      item.NotMatch,
+			ExpectNumber:  item.ExpectNumber,
+			ExpectVersion: item.ExpectVersion,
+			ExpectOneOf:   item.ExpectOneOf,
+			ExpectJSON:    item.ExpectJSON,
+			ExpectExit:    item.ExpectExit,
+			ExpectStderr:  item.ExpectStderr,
+		}
+	}
+	data, _ := json.Marshal(content)
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+/**
+ * Returns the directory of the sessions in the cache directory of the user,
+ * or in a directory of the user in the system temporary directory if there is
+ * no cache directory (ex. without $HOME), creating it if needed
+ */
+func DefaultSessionDir() (string, error) {
+	dir := filepath.Join(os.TempDir(), fmt.Sprintf("preflighter-%d", os.Getuid()))
+	if base, err := os.UserCacheDir(); err == nil {
+		dir = filepath.Join(base, "preflighter", "sessions")
+	}
+
+	err := ensurePrivateDir(dir)
+	if err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+/**
+ * Creates the directory if needed, and checks that it belongs to the current
+ * user and is not accessible by other users, since the sessions contain the
+ * output of the checks
+ */
+func ensurePrivateDir(dir string) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return fmt.Errorf("Could not create session directory: %s", err.Error())
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return fmt.Errorf("Could not check session directory: %s", err.Error())
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("The session directory %s is not a directory", dir)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("The session directory %s belongs to another user", dir)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("The session directory %s is accessible by other users (mode %o)", dir, info.Mode().Perm())
+	}
+	return nil
+}
+
+/**
+ * Returns the path to the session file of the given checklist items
+ */
+func SessionFilename(dir string, items []ChecklistItem) string {
+	return filepath.Join(dir, fmt.Sprintf("session-%s.json", ChecklistChecksum(items)[:16]))
+}
+
+/**
+ * Creates a new, empty session for the given items
+ */
+func CreateSession(filename string, items []ChecklistItem) *Session {
+	s := &Session{
+		Checksum: ChecklistChecksum(items),
+		Items:    make([]SessionItem, len(items)),
+		filename: filename,
+	}
+	for i, item := range items {
+		s.Items[i].Title = item.Title
+	}
+	return s
+}
+
+/**
+ * Loads the session previously persisted for the given items, returning nil
+ * if there is no such session
+ */
+func LoadSession(filename string, items []ChecklistItem) (*Session, error) {
+	content, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not read session %s: %s", filename, err.Error())
+	}
+
+	var s Session
+	err = json.Unmarshal(content, &s)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse session %s: %s", filename, err.Error())
+	}
+	if s.Checksum != ChecklistChecksum(items) || len(s.Items) != len(items) {
+		return nil, nil
+	}
+
+	s.filename = filename
+	return &s, nil
+}
+
+/**
+ * Returns the number of leading items that were resolved in this session and
+ * do not need to be checked again
+ */
+func (s *Session) ResolvedCount() int {
+	for i, item := range s.Items {
+		switch ParseOutcome(item.Outcome) {
+		case OutcomePass, OutcomeSkip, OutcomeNoChecks:
+			continue
+		}
+		return i
+	}
+	return len(s.Items)
+}
+
+/**
+ * Records the outcome of the item with the given index and persists the
+ * session to disk
+ */
+func (s *Session) Record(idx int, res *CheckResult) error {
+	s.Items[idx].Outcome = res.Outcome.String()
+	s.Items[idx].Value = MaskSecrets(res.Stdout)
+	return s.Save()
+}
+
+/**
+ * Persists the session to disk, replacing the previous file atomically
+ */
+func (s *Session) Save() error {
+	s.Updated = time.Now()
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Could not encode session: %s", err.Error())
+	}
+
+	err = os.MkdirAll(filepath.Dir(s.filename), 0700)
+	if err != nil {
+		return fmt.Errorf("Could not create session directory: %s", err.Error())
+	}
+
+	tmpFile := s.filename + ".tmp"
+	err = ioutil.WriteFile(tmpFile, content, 0600)
+	if err != nil {
+		return fmt.Errorf("Could not write session %s: %s", s.filename, err.Error())
+	}
+	return os.Rename(tmpFile, s.filename)
+}
@@ -0,0 +1,91 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnsurePrivateDir(t *testing.T) {
+	dir, remove := writeTestFiles(t, nil)
+	defer remove()
+
+	created := filepath.Join(dir, "a", "sessions")
+	err := ensurePrivateDir(created)
+	if err != nil {
+		t.Fatalf("Expecting the directory to be created, got: %s", err.Error())
+	}
+	info, _ := os.Stat(created)
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("Expecting the directory to be private, got mode %o", info.Mode().Perm())
+	}
+
+	shared := filepath.Join(dir, "shared")
+	os.Mkdir(shared, 0700)
+	os.Chmod(shared, 0777)
+	err = ensurePrivateDir(shared)
+	if err == nil || !strings.Contains(err.Error(), "accessible by other users") {
+		t.Errorf("Expecting a shared directory to be rejected, got: %v", err)
+	}
+
+	link := filepath.Join(dir, "link")
+	os.Symlink(created, link)
+	err = ensurePrivateDir(link)
+	if err == nil || !strings.Contains(err.Error(), "is not a directory") {
+		t.Errorf("Expecting a symbolic link to be rejected, got: %v", err)
+	}
+}
+
+func TestSessionSaveAndLoad(t *testing.T) {
+	dir, remove := writeTestFiles(t, nil)
+	defer remove()
+
+	items := []ChecklistItem{{Title: "First", Script: "true"}, {Title: "Second", Script: "false"}}
+	filename := SessionFilename(filepath.Join(dir, "sessions"), items)
+	session := CreateSession(filename, items)
+	err := session.Record(0, &CheckResult{Outcome: OutcomePass, Stdout: "ok"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, _ := os.Stat(filepath.Dir(filename))
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("Expecting the session directory to be private, got mode %o", info.Mode().Perm())
+	}
+	loaded, err := LoadSession(filename, items)
+	if err != nil || loaded == nil || loaded.ResolvedCount() != 1 {
+		t.Errorf("Expecting the session to be resumed after the first item, got %+v (%v)", loaded, err)
+	}
+
+	loaded, _ = LoadSession(filename, items[:1])
+	if loaded != nil {
+		t.Errorf("Expecting a session of other items not to be loaded")
+	}
+}
+
+func TestChecklistChecksum(t *testing.T) {
+	items := []ChecklistItem{{ID: "first", Title: "First", Script: "true", ExpectMatch: "ok"}}
+	checksum := ChecklistChecksum(items)
+
+	// The same checklist loaded from another directory or with another timeout
+	moved := []ChecklistItem{items[0]}
+	moved[0].Filename = filepath.Join("..", "checklist.yaml")
+	moved[0].Timeout = Duration(time.Minute)
+	if ChecklistChecksum(moved) != checksum {
+		t.Errorf("Expecting the filename and the timeout not to change the checksum")
+	}
+
+	for _, changed := range []ChecklistItem{
+		{ID: "other", Title: "First", Script: "true", ExpectMatch: "ok"},
+		{ID: "first", Title: "Other", Script: "true", ExpectMatch: "ok"},
+		{ID: "first", Title: "First", Script: "false", ExpectMatch: "ok"},
+		{ID: "first", Title: "First", Script: "true", ExpectMatch: "ko"},
+		{ID: "first", Title: "First", Script: "true", ExpectMatch: "ok", ExpectExit: ExitCodes{1}},
+	} {
+		if ChecklistChecksum([]ChecklistItem{changed}) == checksum {
+			t.Errorf("Expecting the checksum to change for %+v", changed)
+		}
+	}
+}
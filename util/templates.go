@@ -0,0 +1,157 @@
+package util
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+)
+
+var rxTemplateArg = regexp.MustCompile(`{{\s*([\w-]+)\s*}}`)
+
+/**
+ * Replaces all the `{{name}}` placeholders in the given text with the
+ * respective arguments
+ */
+func expandTemplateArgs(text string, args map[string]string) (string, error) {
+	var err error
+	result := rxTemplateArg.ReplaceAllStringFunc(text, func(match string) string {
+		name := rxTemplateArg.FindStringSubmatch(match)[1]
+		value, ok := args[name]
+		if !ok {
+			err = fmt.Errorf("Missing template argument '%s'", name)
+			return match
+		}
+		return value
+	})
+	return result, err
+}
+
+/**
+ * Expands the placeholders in all the strings of the given value, including
+ * the strings nested in structs, slices and pointers. The slices and pointers
+ * are copied before being expanded, since they are shared with the template.
+ * The `use` and `with` fields and the fields that are not read from the
+ * checklist file are left as they are.
+ */
+func expandTemplateFields(v reflect.Value, args map[string]string) error {
+	switch v.Kind() {
+	case reflect.String:
+		expanded, err := expandTemplateArgs(v.String(), args)
+		if err != nil {
+			return err
+		}
+		v.SetString(expanded)
+
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		copied := reflect.New(v.Elem().Type())
+		copied.Elem().Set(v.Elem())
+		err := expandTemplateFields(copied.Elem(), args)
+		if err != nil {
+			return err
+		}
+		v.Set(copied)
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(copied, v)
+		for i := 0; i < copied.Len(); i++ {
+			err := expandTemplateFields(copied.Index(i), args)
+			if err != nil {
+				return err
+			}
+		}
+		v.Set(copied)
+
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			switch field.Tag.Get("yaml") {
+			case "use", "with", "-":
+				continue
+			}
+			if field.PkgPath != "" {
+				continue
+			}
+			err := expandTemplateFields(v.Field(i), args)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/**
+ * Creates a checklist item from the given template. The fields given in the
+ * item override the fields of the template, even with a zero value (ex.
+ * `retries: 0`), and the arguments in the `with` field of the item override
+ * the default arguments of the template.
+ */
+func ApplyTemplate(item ChecklistItem, tpl ChecklistItem) (ChecklistItem, error) {
+	if tpl.Use != "" {
+		return item, fmt.Errorf("Template '%s' cannot use another template", item.Use)
+	}
+
+	result := item
+	result.Use = ""
+	result.With = make(map[string]string)
+	for k, v := range tpl.With {
+		result.With[k] = v
+	}
+	for k, v := range item.With {
+		result.With[k] = v
+	}
+
+	// The zero fields of the template keep the value of the item, which may
+	// be a default of the file (ex. the timeout of an included file)
+	rv := reflect.ValueOf(&result).Elem()
+	tv := reflect.ValueOf(tpl)
+	for key, field := range checklistItemFields {
+		if key == "use" || key == "with" || item.hasField(key) {
+			continue
+		}
+		if value := tv.FieldByIndex(field.Index); !value.IsZero() {
+			rv.FieldByIndex(field.Index).Set(value)
+		}
+	}
+
+	err := expandTemplateFields(reflect.ValueOf(&result).Elem(), result.With)
+	if err != nil {
+		return item, fmt.Errorf("Could not apply template '%s': %s", item.Use, err.Error())
+	}
+
+	return result, nil
+}
+
+/**
+ * Replaces all the items that use a template with the respective template
+ * instance
+ */
+func ExpandTemplates(items Checklist, templates map[string]ChecklistItem) (Checklist, error) {
+	var result Checklist
+	for _, item := range items {
+		if item.Use == "" {
+			result = append(result, item)
+			continue
+		}
+
+		tpl, ok := templates[item.Use]
+		if !ok {
+			return nil, fmt.Errorf("Item '%s' uses unknown template '%s'", item.Title, item.Use)
+		}
+
+		expanded, err := ApplyTemplate(item, tpl)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, expanded)
+	}
+	return result, nil
+}
@@ -0,0 +1,113 @@
+package util
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyTemplate(t *testing.T) {
+	equals := "{{state}}"
+	tpl := ChecklistItem{
+		ID:            "{{app}}-running",
+		Title:         "Is {{app}} running?",
+		Script:        "dcos marathon app show /{{app}}",
+		DependsOn:     []string{"{{cluster}}-reachable"},
+		NotMatch:      "{{app}} is stopped",
+		ExpectNumber:  ">= {{replicas}}",
+		ExpectVersion: "^{{version}}",
+		ExpectOneOf:   []string{"{{state}}", "DEPLOYING"},
+		ExpectJSON:    []JSONAssertion{{Path: ".{{field}}", Equals: &equals}},
+		ExpectStderr:  "^$|{{app}}",
+		With:          map[string]string{"replicas": "3"},
+	}
+	item := ChecklistItem{
+		Use: "app",
+		With: map[string]string{
+			"app": "web", "cluster": "mwt", "version": "1.2", "state": "RUNNING", "field": "tasksRunning",
+		},
+	}
+
+	result, err := ApplyTemplate(item, tpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ChecklistItem{
+		ID:            "web-running",
+		Title:         "Is web running?",
+		Script:        "dcos marathon app show /web",
+		DependsOn:     []string{"mwt-reachable"},
+		NotMatch:      "web is stopped",
+		ExpectNumber:  ">= 3",
+		ExpectVersion: "^1.2",
+		ExpectOneOf:   []string{"RUNNING", "DEPLOYING"},
+		ExpectStderr:  "^$|web",
+	}
+	expectedJSON := JSONAssertion{Path: ".tasksRunning"}
+	running := "RUNNING"
+	expectedJSON.Equals = &running
+	expected.ExpectJSON = []JSONAssertion{expectedJSON}
+	expected.With = result.With
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expecting %+v, got %+v", expected, result)
+	}
+
+	// The template is left as it is for the other items using it
+	if tpl.DependsOn[0] != "{{cluster}}-reachable" || tpl.ExpectOneOf[0] != "{{state}}" ||
+		tpl.ExpectJSON[0].Path != ".{{field}}" || equals != "{{state}}" {
+		t.Errorf("The template was modified: %+v", tpl)
+	}
+}
+
+func TestApplyTemplateMissingArgument(t *testing.T) {
+	tpl := ChecklistItem{
+		Title:      "Item",
+		Script:     "true",
+		ExpectJSON: []JSONAssertion{{Path: ".{{field}}"}},
+	}
+	_, err := ApplyTemplate(ChecklistItem{Use: "tpl"}, tpl)
+	if err == nil || !strings.Contains(err.Error(), "Missing template argument 'field'") {
+		t.Errorf("Expecting a missing argument error, got: %v", err)
+	}
+}
+
+func TestApplyTemplateZeroValues(t *testing.T) {
+	dir, remove := writeTestFiles(t, map[string]string{
+		"checklist.yaml": `templates:
+  flaky:
+    title: Is {{name}} up?
+    script: "true"
+    retries: 3
+    retry_delay: 5s
+    retry_until_match: true
+    expect: "up"
+checklist:
+  - use: flaky
+    with: {name: web}
+  - use: flaky
+    with: {name: api}
+    retries: 0
+    retry_delay: 0s
+    retry_until_match: false
+    expect: ""
+    expect_exit: 0
+`,
+	})
+	defer remove()
+
+	cf, err := LoadChecklist(filepath.Join(dir, "checklist.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inherited, overridden := cf.Checklist[0], cf.Checklist[1]
+	if inherited.Retries != 3 || inherited.RetryDelay == 0 || !inherited.RetryUntilMatch || inherited.ExpectMatch != "up" {
+		t.Errorf("Expecting the fields of the template, got %+v", inherited)
+	}
+	if overridden.Retries != 0 || overridden.RetryDelay != 0 || overridden.RetryUntilMatch || overridden.ExpectMatch != "" {
+		t.Errorf("Expecting the zero values of the item, got %+v", overridden)
+	}
+	if overridden.Title != "Is api up?" || overridden.Script != "true" {
+		t.Errorf("Expecting the other fields of the template, got %+v", overridden)
+	}
+}
@@ -0,0 +1,241 @@
+package util
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// The terminal state to restore when leaving raw mode, or nil if the
+// terminal is not in raw mode
+var savedTermios *syscall.Termios
+var termiosLock sync.Mutex
+
+// The keys that were read ahead, returned first by readRawKey
+var pendingKeys []string
+
+// Set while the full-screen UI is using the alternate screen buffer
+var altScreen bool
+var signalOnce sync.Once
+
+func getTermios(fd uintptr) (*syscall.Termios, error) {
+	t := &syscall.Termios{}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(ioctlGetTermios), uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return nil, errno
+	}
+	return t, nil
+}
+
+func setTermios(fd uintptr, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(ioctlSetTermios), uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+/**
+ * Makes sure the running scripts are terminated and the terminal state is
+ * restored if we are interrupted
+ */
+func installSignalHandler() {
+	signalOnce.Do(func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+		go func() {
+			sig := <-ch
+			killRunningScripts(2 * time.Second)
+			UxRestoreTerminal()
+			fmt.Println()
+			if s, ok := sig.(syscall.Signal); ok {
+				os.Exit(128 + int(s))
+			}
+			os.Exit(1)
+		}()
+	})
+}
+
+/**
+ * Puts the terminal in raw mode, where every key press is delivered
+ * immediately and without echo
+ */
+func enableRawMode() error {
+	termiosLock.Lock()
+	defer termiosLock.Unlock()
+	if savedTermios != nil {
+		return nil
+	}
+
+	fd := uintptr(syscall.Stdin)
+	orig, err := getTermios(fd)
+	if err != nil {
+		return err
+	}
+
+	installSignalHandler()
+
+	// Keep the output processing and signal generation, so that newlines and
+	// Ctrl+C keep working as usual
+	raw := *orig
+	raw.Iflag &^= syscall.ICRNL | syscall.IXON | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.IEXTEN
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	err = setTermios(fd, &raw)
+	if err != nil {
+		return err
+	}
+
+	savedTermios = orig
+	return nil
+}
+
+/**
+ * Switches to the alternate screen buffer and hides the cursor, preserving
+ * the contents of the terminal
+ */
+func enterAltScreen() {
+	termiosLock.Lock()
+	defer termiosLock.Unlock()
+	if !altScreen {
+		fmt.Print("\x1B[?1049h\x1B[?25l\x1B[H\x1B[2J")
+		altScreen = true
+	}
+}
+
+/**
+ * Restores the terminal state, if it was changed
+ */
+func UxRestoreTerminal() {
+	termiosLock.Lock()
+	defer termiosLock.Unlock()
+	if altScreen {
+		fmt.Print("\x1B[?25h\x1B[?1049l")
+		altScreen = false
+	}
+	if savedTermios != nil {
+		setTermios(uintptr(syscall.Stdin), savedTermios)
+		savedTermios = nil
+	}
+}
+
+/**
+ * Makes the given key the next one returned by readRawKey
+ */
+func unreadKey(key string) {
+	pendingKeys = append(pendingKeys, key)
+}
+
+/**
+ * Reads a single key press from the terminal, without waiting for Enter.
+ * Special keys are returned by name ("enter", "esc", "up", "down", "left",
+ * "right", "backspace", "tab"). If the terminal cannot be put in raw mode, a
+ * whole line is read instead.
+ */
+func readKey() string {
+	if err := enableRawMode(); err != nil {
+		return readChar()
+	}
+	defer UxRestoreTerminal()
+	return readRawKey()
+}
+
+/**
+ * Reads a single key press, expecting the terminal to be in raw mode
+ */
+func readRawKey() string {
+	if len(pendingKeys) > 0 {
+		key := pendingKeys[0]
+		pendingKeys = pendingKeys[1:]
+		return key
+	}
+
+	buf := make([]byte, 8)
+	n, err := os.Stdin.Read(buf)
+	if err != nil || n == 0 {
+		return ""
+	}
+
+	switch {
+	case buf[0] == '\r' || buf[0] == '\n':
+		return "enter"
+	case buf[0] == '\t':
+		return "tab"
+	case buf[0] == 127 || buf[0] == 8:
+		return "backspace"
+	case buf[0] == 27 && n >= 3 && (buf[1] == '[' || buf[1] == 'O'):
+		switch buf[2] {
+		case 'A':
+			return "up"
+		case 'B':
+			return "down"
+		case 'C':
+			return "right"
+		case 'D':
+			return "left"
+		case '5':
+			return "pgup"
+		case '6':
+			return "pgdown"
+		}
+		return "esc"
+	case buf[0] == 27:
+		return "esc"
+	}
+	return string(buf[:n])
+}
+
+/**
+ * Reads a line of text in raw mode, echoing a `*` for every character if the
+ * input is hidden. If the terminal cannot be put in raw mode, the line is
+ * read as usual.
+ */
+func readLine(hidden bool) string {
+	if err := enableRawMode(); err != nil {
+		return readChar()
+	}
+	defer UxRestoreTerminal()
+
+	var line []rune
+	for {
+		key := readRawKey()
+		switch key {
+		case "", "enter":
+			fmt.Println()
+			return strings.TrimSpace(string(line))
+		case "backspace":
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				fmt.Print("\b \b")
+			}
+		case "tab", "esc", "up", "down", "left", "right", "pgup", "pgdown":
+		default:
+			for _, r := range key {
+				if r < ' ' {
+					continue
+				}
+				line = append(line, r)
+				if hidden {
+					fmt.Print("*")
+				} else {
+					fmt.Print(string(r))
+				}
+			}
+		}
+	}
+}
+
+/**
+ * Copies the given text to the clipboard of the terminal, using the OSC 52
+ * escape sequence (works over SSH with most terminal emulators)
+ */
+func copyToClipboard(text string) {
+	fmt.Printf("\x1B]52;c;%s\x07", base64.StdEncoding.EncodeToString([]byte(text)))
+}
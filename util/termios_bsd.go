@@ -0,0 +1,27 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package util
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const ioctlGetTermios = syscall.TIOCGETA
+const ioctlSetTermios = syscall.TIOCSETA
+
+/**
+ * Waits up to the given time for the standard input to be readable
+ */
+func waitForInput(timeout time.Duration) bool {
+	// struct pollfd, with POLLIN as the requested event
+	fds := struct {
+		fd      int32
+		events  int16
+		revents int16
+	}{int32(syscall.Stdin), 0x1, 0}
+	n, _, errno := syscall.Syscall(syscall.SYS_POLL, uintptr(unsafe.Pointer(&fds)), 1, uintptr(timeout.Milliseconds()))
+	return errno == 0 && n > 0
+}
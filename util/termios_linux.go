@@ -0,0 +1,20 @@
+package util
+
+import (
+	"syscall"
+	"time"
+)
+
+const ioctlGetTermios = syscall.TCGETS
+const ioctlSetTermios = syscall.TCSETS
+
+/**
+ * Waits up to the given time for the standard input to be readable
+ */
+func waitForInput(timeout time.Duration) bool {
+	set := &syscall.FdSet{}
+	set.Bits[0] = 1 << uint(syscall.Stdin)
+	tv := syscall.NsecToTimeval(timeout.Nanoseconds())
+	n, err := syscall.Select(syscall.Stdin+1, set, nil, nil, &tv)
+	return err == nil && n > 0
+}
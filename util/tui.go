@@ -0,0 +1,572 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The spinner frames shown next to a running item
+var tuiSpinner = []string{"|", "/", "-", "\\"}
+
+/**
+ * The state of an item in the full-screen UI
+ */
+type tuiItem struct {
+	item    *ChecklistItem
+	result  CheckResult
+	err     error
+	ran     bool
+	running bool
+	lines   []string
+}
+
+/**
+ * An event handled by the main loop of the full-screen UI: a key press, a
+ * line streamed by the running script, or the completion of a script
+ */
+type tuiEvent struct {
+	key  string
+	idx  int
+	line string
+	done bool
+	res  CheckResult
+	err  error
+}
+
+type uxTUI struct {
+	title     string
+	items     []tuiItem
+	graph     *DependencyGraph
+	runner    *Runner
+	onResolve func(int, *CheckResult) error
+
+	selected  int
+	listTop   int
+	detailTop int
+	running   int
+	frame     int
+	message   string
+	goTo      string
+	skipping  bool
+	reason    string
+	events    chan tuiEvent
+}
+
+/**
+ * Runs the given items in a full-screen UI, with a list of all the items and
+ * their status, and the script, output and stderr of the selected item. The
+ * operator can move freely between the items, re-run, pass, fail or skip any
+ * of them, and quits when done. The `onResolve` callback is invoked every
+ * time the operator resolves an item, and the error it returns is displayed.
+ * The results of all the items are returned, with OutcomeNone for the items
+ * left unresolved.
+ */
+func UxRunTUI(title string, items []ChecklistItem, runner *Runner, onResolve func(int, *CheckResult) error) ([]CheckResult, error) {
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	t := &uxTUI{
+		title:     title,
+		items:     make([]tuiItem, len(items)),
+		graph:     CreateDependencyGraph(items),
+		runner:    runner,
+		onResolve: onResolve,
+		running:   -1,
+		events:    make(chan tuiEvent, 64),
+	}
+	for i := range items {
+		t.items[i].item = &items[i]
+	}
+
+	err := enableRawMode()
+	if err != nil {
+		return nil, fmt.Errorf("Could not use the terminal: %s", err.Error())
+	}
+	enterAltScreen()
+	defer UxRestoreTerminal()
+
+	// Feed the key presses to the main loop, which also redraws the screen
+	// when the terminal is resized and animates the spinner. The reader
+	// polls the input, so that it can be stopped before returning instead of
+	// stealing the next key press from the prompts that follow.
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if !waitForInput(100 * time.Millisecond) {
+				continue
+			}
+			key := readRawKey()
+			if key == "" {
+				return
+			}
+			select {
+			case t.events <- tuiEvent{key: key}:
+			case <-stop:
+				unreadKey(key)
+				return
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-stopped
+
+		// Hand back the keys pressed after quitting
+		for {
+			select {
+			case ev := <-t.events:
+				if ev.key != "" {
+					unreadKey(ev.key)
+				}
+			default:
+				return
+			}
+		}
+	}()
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+	ticker := time.NewTicker(150 * time.Millisecond)
+	defer ticker.Stop()
+
+	t.run(0)
+	for {
+		t.draw()
+		select {
+		case <-winch:
+		case <-ticker.C:
+			if t.running < 0 {
+				continue
+			}
+			t.frame++
+		case ev := <-t.events:
+			if !t.handle(ev) {
+				results := make([]CheckResult, len(t.items))
+				for i := range t.items {
+					results[i] = t.items[i].result
+				}
+				return results, nil
+			}
+		}
+	}
+}
+
+/**
+ * Runs the script of the given item in the background
+ */
+func (t *uxTUI) run(idx int) {
+	ti := &t.items[idx]
+	if t.running >= 0 {
+		t.message = "Another item is still running"
+		return
+	}
+	if dep := t.graph.BlockedBy(idx, t.outcomes()); dep >= 0 {
+		t.message = fmt.Sprintf("Blocked by '%s'", t.items[dep].item.Title)
+		return
+	}
+
+	t.running = idx
+	ti.running = true
+	ti.lines = nil
+	ti.err = nil
+	t.message = ""
+	go func() {
+		check := ti.item.RetryUntilMatch && CanCheckItem(ti.item)
+		res, _, err := RunItemAttempts(ti.item, t.runner, func(line string) {
+			t.events <- tuiEvent{idx: idx, line: line}
+		}, check)
+		t.events <- tuiEvent{idx: idx, done: true, res: res, err: err}
+	}()
+}
+
+/**
+ * Returns the outcomes of all the items, as needed by the dependency graph
+ */
+func (t *uxTUI) outcomes() []Outcome {
+	outcomes := make([]Outcome, len(t.items))
+	for i := range t.items {
+		outcomes[i] = t.items[i].result.Outcome
+	}
+	return outcomes
+}
+
+/**
+ * Checks if the selected item can be resolved with the given outcome,
+ * explaining why not otherwise
+ */
+func (t *uxTUI) canResolve(outcome Outcome) bool {
+	ti := &t.items[t.selected]
+	if ti.running {
+		t.message = "The item is still running"
+		return false
+	}
+	if !ti.ran {
+		t.message = "The item has not been run yet, press r to run it"
+		return false
+	}
+	if ti.err != nil && outcome == OutcomePass {
+		t.message = "The script has failed, press r to re-run it or n to fail it"
+		return false
+	}
+	return true
+}
+
+/**
+ * Resolves the selected item with the given outcome and moves on to the next
+ * unresolved item
+ */
+func (t *uxTUI) resolve(outcome Outcome) {
+	ti := &t.items[t.selected]
+	if !t.canResolve(outcome) {
+		return
+	}
+	if outcome == OutcomeFail && ti.result.TimedOut {
+		outcome = OutcomeTimeout
+	}
+
+	ti.result.Outcome = outcome
+	err := t.onResolve(t.selected, &ti.result)
+
+	next := t.nextUnresolved(t.selected)
+	if next < 0 {
+		t.message = "All the items are resolved, press q to finish"
+	} else {
+		t.selectItem(next)
+		if !t.items[next].ran && t.running < 0 {
+			t.run(next)
+		}
+	}
+	if err != nil {
+		t.message = "Could not update the runbook: " + MaskSecrets(err.Error())
+	}
+}
+
+/**
+ * Returns the index of the first unresolved item that is not blocked after
+ * the given one (wrapping around), or -1 if there is none
+ */
+func (t *uxTUI) nextUnresolved(from int) int {
+	outcomes := t.outcomes()
+	for i := 1; i <= len(t.items); i++ {
+		idx := (from + i) % len(t.items)
+		if outcomes[idx] == OutcomeNone && t.graph.BlockedBy(idx, outcomes) < 0 {
+			return idx
+		}
+	}
+	return -1
+}
+
+func (t *uxTUI) selectItem(idx int) {
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(t.items) {
+		idx = len(t.items) - 1
+	}
+	if idx != t.selected {
+		t.detailTop = 0
+	}
+	t.selected = idx
+}
+
+/**
+ * Handles the given event, returning false if the operator has quit
+ */
+func (t *uxTUI) handle(ev tuiEvent) bool {
+	if ev.key == "" {
+		ti := &t.items[ev.idx]
+		if !ev.done {
+			ti.lines = append(ti.lines, ev.line)
+			return true
+		}
+
+		ti.result = ev.res
+		ti.err = ev.err
+		ti.ran = true
+		ti.running = false
+		t.running = -1
+		return true
+	}
+
+	// Collect the optional reason for skipping the selected item
+	if t.skipping {
+		switch ev.key {
+		case "enter":
+			t.skipping = false
+			t.items[t.selected].result.Reason = strings.TrimSpace(t.reason)
+			t.resolve(OutcomeSkip)
+			return true
+		case "esc":
+			t.skipping = false
+			t.message = ""
+			return true
+		case "backspace":
+			if runes := []rune(t.reason); len(runes) > 0 {
+				t.reason = string(runes[:len(runes)-1])
+			}
+		case "tab", "up", "down", "left", "right", "pgup", "pgdown":
+		default:
+			t.reason += ev.key
+		}
+		t.message = "Reason for skipping (optional): " + t.reason
+		return true
+	}
+
+	// Collect the digits of the item number to jump to
+	if len(ev.key) == 1 && ev.key[0] >= '0' && ev.key[0] <= '9' {
+		t.goTo += ev.key
+		t.message = "Go to item: " + t.goTo
+		return true
+	}
+	if t.goTo != "" {
+		goTo := t.goTo
+		t.goTo = ""
+		t.message = ""
+		switch ev.key {
+		case "enter":
+			num, _ := strconv.Atoi(goTo)
+			t.selectItem(num - 1)
+			return true
+		case "backspace":
+			t.goTo = goTo[:len(goTo)-1]
+			if t.goTo != "" {
+				t.message = "Go to item: " + t.goTo
+			}
+			return true
+		case "esc":
+			return true
+		}
+	}
+
+	t.message = ""
+	switch ev.key {
+	case "up", "k":
+		t.selectItem(t.selected - 1)
+	case "down", "j":
+		t.selectItem(t.selected + 1)
+	case "tab":
+		if next := t.nextUnresolved(t.selected); next >= 0 {
+			t.selectItem(next)
+		}
+	case "pgup":
+		t.detailTop -= 10
+		if t.detailTop < 0 {
+			t.detailTop = 0
+		}
+	case "pgdown":
+		t.detailTop += 10
+	case "r", "R":
+		t.run(t.selected)
+	case "enter":
+		if !t.items[t.selected].ran && !t.items[t.selected].running {
+			t.run(t.selected)
+		} else {
+			t.resolve(OutcomePass)
+		}
+	case "y", "Y":
+		t.resolve(OutcomePass)
+	case "n", "N":
+		t.resolve(OutcomeFail)
+	case "s", "S":
+		if t.canResolve(OutcomeSkip) {
+			t.skipping = true
+			t.reason = ""
+			t.message = "Reason for skipping (optional): "
+		}
+	case "c", "C":
+		copyToClipboard(MaskSecrets(t.items[t.selected].result.Stdout))
+		t.message = "Copied!"
+	case "q", "Q":
+		if t.running >= 0 {
+			t.message = "Wait for the running item to complete before quitting"
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+/**
+ * Returns the status label of the given item
+ */
+func (t *uxTUI) status(idx int, outcomes []Outcome) (string, string) {
+	ti := &t.items[idx]
+	switch {
+	case ti.running:
+		return tuiSpinner[t.frame%len(tuiSpinner)], "RUNNING"
+	case outcomes[idx] == OutcomePass:
+		return "✔", "PASS"
+	case outcomes[idx] == OutcomeFail:
+		return "✘", "FAIL"
+	case outcomes[idx] == OutcomeTimeout:
+		return "✘", "TIMEOUT"
+	case outcomes[idx] == OutcomeSkip:
+		return "-", "SKIP"
+	case t.graph.BlockedBy(idx, outcomes) >= 0:
+		return "#", "BLOCKED"
+	case ti.err != nil:
+		return "!", "ERROR"
+	case ti.ran:
+		return "?", "OK?"
+	}
+	return " ", ""
+}
+
+/**
+ * Returns the lines of the detail pane of the selected item
+ */
+func (t *uxTUI) detail() []string {
+	ti := &t.items[t.selected]
+	var lines []string
+	section := func(title string, text string) {
+		lines = append(lines, "╒ "+title)
+		for _, line := range strings.Split(strings.TrimRight(text, "\r\n"), "\n") {
+			lines = append(lines, "│ "+line)
+		}
+		lines = append(lines, "")
+	}
+
+	section("Script", ti.item.Script)
+	if ti.running {
+		section("Progress", strings.Join(ti.lines, "\n"))
+		return lines
+	}
+	if !ti.ran {
+		return lines
+	}
+	if ti.err != nil {
+		section("Error", ti.err.Error())
+	}
+	section("Output", ti.result.Stdout)
+	if ti.result.Stderr != "" {
+		section("Stderr", ti.result.Stderr)
+	}
+	if ti.result.ExpectDetails != "" {
+		section("Expectations", ti.result.ExpectDetails)
+	}
+	return lines
+}
+
+/**
+ * Truncates or pads the given text to exactly the given width
+ */
+func fitWidth(text string, width int) string {
+	text = strings.Replace(text, "\t", "    ", -1)
+	runes := []rune(text)
+	if len(runes) > width {
+		if width < 1 {
+			return ""
+		}
+		return string(runes[:width-1]) + "…"
+	}
+	return text + strings.Repeat(" ", width-len(runes))
+}
+
+/**
+ * Renders the whole screen
+ */
+func (t *uxTUI) draw() {
+	width, height := 80, 24
+	if ws, ok := getWinsize(uintptr(syscall.Stdout)); ok && ws.Col > 0 && ws.Row > 0 {
+		width, height = int(ws.Col), int(ws.Row)
+	}
+
+	outcomes := t.outcomes()
+	resolved := 0
+	for _, o := range outcomes {
+		if o != OutcomeNone {
+			resolved++
+		}
+	}
+
+	var out []string
+	header := fmt.Sprintf(" %s Pre-Flight Checklist (%d/%d resolved)", t.title, resolved, len(t.items))
+	out = append(out, au.Reverse(fitWidth(header, width)).String())
+
+	// The item list takes up to half of the screen, scrolled so that the
+	// selected item is always visible
+	listHeight := len(t.items)
+	if max := (height - 3) / 2; listHeight > max {
+		listHeight = max
+	}
+	if t.selected < t.listTop {
+		t.listTop = t.selected
+	}
+	if t.selected >= t.listTop+listHeight {
+		t.listTop = t.selected - listHeight + 1
+	}
+	valueWidth := width - 54
+	if valueWidth < 10 {
+		valueWidth = 10
+	}
+	for idx := t.listTop; idx < t.listTop+listHeight && idx < len(t.items); idx++ {
+		ti := &t.items[idx]
+		icon, label := t.status(idx, outcomes)
+		value := ""
+		if ti.ran && !ti.running {
+			value = ti.result.Stdout
+			if ti.err != nil {
+				value = ti.err.Error()
+			}
+			value = strings.Replace(MaskSecrets(value), "\n", " ", -1)
+		}
+		line := fitWidth(fmt.Sprintf(" %3d %s %s %s %s", idx+1, icon,
+			fitWidth(ti.item.Title, 35), fitWidth(value, valueWidth), label), width)
+
+		switch {
+		case idx == t.selected:
+			out = append(out, au.Reverse(au.Bold(line)).String())
+		case label == "PASS":
+			out = append(out, au.Green(line).String())
+		case label == "FAIL" || label == "TIMEOUT" || label == "ERROR":
+			out = append(out, au.Red(line).String())
+		case label == "SKIP" || label == "BLOCKED":
+			out = append(out, au.Yellow(line).String())
+		default:
+			out = append(out, line)
+		}
+	}
+
+	// The detail pane fills the rest of the screen except for the footer
+	title := fmt.Sprintf("── %d. %s ", t.selected+1, t.items[t.selected].item.Title)
+	if pad := width - len([]rune(title)); pad > 0 {
+		title += strings.Repeat("─", pad)
+	}
+	out = append(out, au.Bold(fitWidth(title, width)).String())
+	detail := t.detail()
+	detailHeight := height - len(out) - 1
+	if t.detailTop > len(detail)-detailHeight {
+		t.detailTop = len(detail) - detailHeight
+	}
+	if t.detailTop < 0 {
+		t.detailTop = 0
+	}
+	for i := 0; i < detailHeight; i++ {
+		line := ""
+		if t.detailTop+i < len(detail) {
+			line = detail[t.detailTop+i]
+		}
+		out = append(out, fitWidth(MaskSecrets(line), width))
+	}
+
+	footer := t.message
+	if footer == "" {
+		footer = "↑↓ select  enter/y pass  n fail  s skip  r run  c copy  tab next  0-9 go to  pgup/pgdn scroll  q quit"
+	}
+	out = append(out, au.Reverse(fitWidth(" "+footer, width)).String())
+
+	os.Stdout.WriteString("\x1B[H" + strings.Join(out, "\n"))
+}
@@ -10,7 +10,7 @@ import (
 	"unsafe"
 
 	"github.com/briandowns/spinner"
-	. "github.com/logrusorgru/aurora"
+	"github.com/logrusorgru/aurora"
 )
 
 const PENDING = 0
@@ -20,6 +20,17 @@ const SUCCESS = 3
 const SKIP = 4
 const BLANK = 5
 
+// The colorizer used for all output, disabled in plain mode
+var au = aurora.NewAurora(true)
+
+// Set when the output is not a terminal, in which case no colors or cursor
+// movements are used
+var uxPlain = false
+
+// Set when the failed items are revisited at the end of the checklist, in
+// which case the operator is not offered to re-check them right away
+var uxContinueOnFail = false
+
 type winsize struct {
 	Row    uint16
 	Col    uint16
@@ -27,24 +38,58 @@ type winsize struct {
 	Ypixel uint16
 }
 
-type CheckResult struct {
-	Stdout string
-	Stderr string
-}
-
-func getWidth() uint {
+func getWinsize(fd uintptr) (*winsize, bool) {
 	ws := &winsize{}
-	retCode, _, errno := syscall.Syscall(syscall.SYS_IOCTL,
-		uintptr(syscall.Stdin),
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL,
+		fd,
 		uintptr(syscall.TIOCGWINSZ),
 		uintptr(unsafe.Pointer(ws)))
 
-	if int(retCode) == -1 {
-		panic(errno)
+	return ws, errno == 0
+}
+
+func getWidth() uint {
+	ws, ok := getWinsize(uintptr(syscall.Stdin))
+	if !ok || ws.Col == 0 {
+		return 80
 	}
 	return uint(ws.Col)
 }
 
+/**
+ * Checks if both stdin and stdout are connected to a terminal, so the
+ * operator can be prompted
+ */
+func UxIsInteractive() bool {
+	_, inTty := getWinsize(uintptr(syscall.Stdin))
+	_, outTty := getWinsize(uintptr(syscall.Stdout))
+	return inTty && outTty
+}
+
+/**
+ * Checks if stdout is connected to a terminal
+ */
+func UxIsTerminal() bool {
+	_, ok := getWinsize(uintptr(syscall.Stdout))
+	return ok
+}
+
+/**
+ * Enables or disables the plain, line-oriented renderer without colors, to
+ * be used when the output is not a terminal
+ */
+func UxSetPlain(plain bool) {
+	uxPlain = plain
+	au = aurora.NewAurora(!plain)
+}
+
+/**
+ * Enables or disables offering to re-check a failed item right away
+ */
+func UxSetContinueOnFail(enabled bool) {
+	uxContinueOnFail = enabled
+}
+
 type UxPendingMonitor struct {
 	item          *ChecklistItem
 	spinner       *spinner.Spinner
@@ -71,11 +116,16 @@ func createPendingMonitor(item *ChecklistItem, expandTimeout time.Duration) *UxP
 
 func (m *UxPendingMonitor) Start() {
 	printLine(PENDING, m.item.Title, "", "")
-	m.spinner.Start()
 	m.started = time.Now()
+	if !uxPlain {
+		m.spinner.Start()
+	}
 }
 
 func (m *UxPendingMonitor) Stop() {
+	if uxPlain {
+		return
+	}
 	if m.expanded {
 		m.collapseLines()
 		m.expanded = false
@@ -83,7 +133,16 @@ func (m *UxPendingMonitor) Stop() {
 	m.spinner.Stop()
 }
 
+/**
+ * Collects a line of the output of the script, showing the last lines under
+ * the item once it has been running for a while. The progress can't be
+ * redrawn in plain mode, so the lines are not shown.
+ */
 func (m *UxPendingMonitor) HandleLine(line string) {
+	if uxPlain {
+		return
+	}
+
 	// Shift liens and collect the new line
 	for i := 0; i < m.lineCount-1; i++ {
 		m.lines[i] = m.lines[i+1]
@@ -107,11 +166,11 @@ func (m *UxPendingMonitor) collapseLines() {
 
 func (m *UxPendingMonitor) printLines() {
 	fmt.Println()
-	fmt.Println(Bold("     ╒ Progress"))
+	fmt.Println(au.Bold("     ╒ Progress"))
 	for _, line := range m.lines {
-		fmt.Println(Bold("     │ "), line)
+		fmt.Println(au.Bold("     │ "), MaskSecrets(line))
 	}
-	fmt.Println(Bold("     ╘ ∙∙∙"))
+	fmt.Println(au.Bold("     ╘ ∙∙∙"))
 }
 
 func (m *UxPendingMonitor) redrawLines() {
@@ -143,7 +202,15 @@ func readChar() string {
 	return strings.Trim(text, "\r\n\t ")
 }
 
+/**
+ * Clears the current line, to be replaced with the next one. In plain mode
+ * the next line is printed below instead.
+ */
 func rewindLine() {
+	if uxPlain {
+		fmt.Println()
+		return
+	}
 	fmt.Printf("\r\x1B[K")
 }
 
@@ -158,13 +225,17 @@ func printLine(status int, title string, value interface{}, prompt string) {
 		icon = "❔"
 	case ERROR:
 		icon = "❗️"
-		wrapText = func(v interface{}) interface{} { return Bold(Red(v)) }
+		wrapText = func(v interface{}) interface{} { return au.Bold(au.Red(v)) }
 	case SUCCESS:
 		icon = "✅"
-		wrapText = func(v interface{}) interface{} { return Bold(Green(v)) }
+		wrapText = func(v interface{}) interface{} { return au.Bold(au.Green(v)) }
 	case SKIP:
 		icon = "  "
-		wrapText = func(v interface{}) interface{} { return Yellow(v) }
+		wrapText = func(v interface{}) interface{} { return au.Yellow(v) }
+	}
+
+	if text, ok := value.(string); ok {
+		value = MaskSecrets(text)
 	}
 
 	fmt.Printf("  %s  %-35s : ", icon, wrapText(title))
@@ -177,19 +248,123 @@ func printLine(status int, title string, value interface{}, prompt string) {
 }
 
 func printBlock(block string, title string) {
-	fmt.Println(Bold("     ╒ " + title))
-	lines := strings.Split(block, "\n")
+	fmt.Println(au.Bold("     ╒ " + title))
+	lines := strings.Split(MaskSecrets(block), "\n")
 	for _, line := range lines {
 		if line == "" {
 			continue
 		}
-		fmt.Println(Bold("     │ "), line)
+		fmt.Println(au.Bold("     │ "), line)
 	}
-	fmt.Println(Bold("     ╘ ●"))
+	fmt.Println(au.Bold("     ╘ ●"))
 }
 
 func UxPrintError(err error) {
-	fmt.Println(Bold(Red("ERROR:")), Bold(White(err.Error())))
+	fmt.Println(au.Bold(au.Red("ERROR:")), au.Bold(au.White(MaskSecrets(err.Error()))))
+}
+
+/**
+ * Prints a problem that doesn't stop the run, to the standard error so that
+ * it doesn't mix with exported checklists
+ */
+func UxPrintWarning(message string) {
+	fmt.Fprintln(os.Stderr, au.Bold(au.Yellow("WARNING:")), MaskSecrets(message))
+}
+
+/**
+ * Prints the final verdict of the checklist
+ */
+func UxPrintVerdict(passed bool) {
+	fmt.Println()
+	if passed {
+		fmt.Println("🍺 ", au.Bold("All checks are passing. You are clear to continue"))
+	} else {
+		fmt.Println("🚨 ", au.Bold(au.Red("There was a failed item. You are not clear to continue")))
+	}
+}
+
+/**
+ * Prints the outcome of validating the given checklist file
+ */
+func UxPrintValidation(filename string, issues []ValidationIssue) {
+	if len(issues) == 0 {
+		fmt.Printf("%s %s\n", au.Bold(au.Green("OK")), filename)
+		return
+	}
+	for _, issue := range issues {
+		fmt.Printf("%s %s\n", au.Bold(au.Red("ERROR")), issue.String())
+	}
+}
+
+/**
+ * Asks the operator a yes/no question, defaulting to yes
+ */
+func UxConfirm(question string) bool {
+	for {
+		fmt.Printf("%s [Y/n] ", au.Bold(question))
+		c := readKey()
+		fmt.Println()
+		switch c {
+		case "y", "Y", "enter":
+			return true
+		case "n", "N", "q", "Q":
+			return false
+		}
+	}
+}
+
+/**
+ * Asks the operator for the value of a required variable, until a valid
+ * value is given. The input of secret variables is hidden.
+ */
+func UxPromptVar(name string, spec *VarSpec, def string) string {
+	fmt.Printf("%s %s", au.Bold("The variable"), au.Bold(au.Yellow(name)))
+	if spec.Description != "" {
+		fmt.Printf(" (%s)", spec.Description)
+	}
+	fmt.Println(au.Bold(" is required"))
+	if spec.Type == "enum" {
+		fmt.Printf("   One of: %s\n", strings.Join(spec.Values, ", "))
+	}
+
+	for {
+		if def != "" && !spec.Secret {
+			fmt.Printf("   %s [%s]: ", name, def)
+		} else {
+			fmt.Printf("   %s: ", name)
+		}
+
+		value := readLine(spec.Secret)
+		if value == "" {
+			value = def
+		}
+		if value == "" {
+			continue
+		}
+		if err := spec.Check(value); err != nil {
+			fmt.Printf("   %s\n", au.Red(err.Error()))
+			continue
+		}
+		return value
+	}
+}
+
+/**
+ * Warns that the outcome of the item could not be reported to runbook
+ */
+func UxRunbookUpdateFailed(item *ChecklistItem, err error) {
+	fmt.Println("     ", au.Bold(au.Yellow("Runbook item "+item.RunbookID+" was not updated:")), MaskSecrets(err.Error()))
+}
+
+/**
+ * Prints the outcome of a single operation of a batch
+ */
+func UxPrintCheck(name string, err error) {
+	if err == nil {
+		fmt.Printf("%s %s\n", au.Bold(au.Green("OK")), name)
+		return
+	}
+	fmt.Printf("%s %s: %s\n", au.Bold(au.Red("FAIL")), name, err.Error())
 }
 
 func UxBlankItem(item *ChecklistItem) {
@@ -197,6 +372,33 @@ func UxBlankItem(item *ChecklistItem) {
 	fmt.Println()
 }
 
+func UxResumedItem(item *ChecklistItem, value string, outcome Outcome) {
+	if value == "" {
+		value = "---"
+	}
+	printLine(BLANK, item.Title, value, strings.ToUpper(outcome.String())+" (resumed)")
+	fmt.Println()
+}
+
+/**
+ * Renders a single line summarizing how the item was resolved
+ */
+func UxResolvedItem(item *ChecklistItem, res *CheckResult) {
+	value := res.Stdout
+	if value == "" {
+		value = "---"
+	}
+	switch res.Outcome {
+	case OutcomePass:
+		printLine(SUCCESS, item.Title, value, "PASS")
+	case OutcomeFail, OutcomeTimeout:
+		printLine(ERROR, item.Title, value, strings.ToUpper(res.Outcome.String()))
+	default:
+		printLine(SKIP, item.Title, value, strings.ToUpper(res.Outcome.String()))
+	}
+	fmt.Println()
+}
+
 func UxSkipItem(item *ChecklistItem, reason string) {
 	printLine(SKIP, item.Title, "---", reason)
 	fmt.Println()
@@ -215,56 +417,123 @@ func UxFailItem(item *ChecklistItem, value string, cerr string) {
 	fmt.Println()
 }
 
-func UxCheckItem(item *ChecklistItem, runner *Runner) (bool, CheckResult) {
-	var res CheckResult
+func UxTimeoutItem(item *ChecklistItem, err error, cerr string) {
+	printLine(ERROR, item.Title, err.Error(), "TIMEOUT")
+	fmt.Println()
+	printBlock(item.Script, "Script")
+	printBlock(cerr, "Command Output")
+	fmt.Println()
+}
+
+/**
+ * Renders the outcome of an item checked without operator intervention
+ */
+func UxAutoItem(item *ChecklistItem, res *CheckResult, err error) {
+	switch res.Outcome {
+	case OutcomeNoChecks:
+		UxSkipItem(item, "NO CHECKS")
+	case OutcomeTimeout:
+		UxTimeoutItem(item, err, res.Stderr)
+	case OutcomePass:
+		UxPassItem(item, res.Stdout)
+	default:
+		if err != nil {
+			UxFailItem(item, err.Error(), res.Stderr)
+		} else {
+			UxFailItem(item, res.Stdout, res.ExpectDetails)
+		}
+	}
+}
+
+/**
+ * Runs the item and asks the operator to confirm the value, with a single
+ * key press, returning the result with the outcome decided by the operator:
+ *
+ * - y/Enter : The value is correct
+ * - n       : The value is not correct, offering to re-check it once fixed
+ *             (unless the failed items are revisited at the end), or to
+ *             stop the run with the item failed
+ * - s       : Skip the item, with an optional reason
+ * - v       : Show the script and its output
+ * - r       : Re-run the script
+ * - c       : Copy the value to the clipboard
+ * - q       : Quit, aborting the rest of the checklist
+ */
+func UxCheckItem(item *ChecklistItem, runner *Runner) CheckResult {
 	for {
 		moni := createPendingMonitor(item, 10*time.Second)
 		moni.Start()
-		runner.StderrCallback = moni.HandleLine
-		sout, serr, err := RunItemScript(item, runner)
-
-		res.Stdout = sout
-		res.Stderr = serr
+		check := item.RetryUntilMatch && CanCheckItem(item)
+		res, _, err := RunItemAttempts(item, runner, moni.HandleLine, check)
+		sout := MaskSecrets(res.Stdout)
+		serr := res.Stderr
 
 		moni.Stop()
 		if err != nil {
+			label := "ERROR"
+			if res.TimedOut {
+				label = "TIMEOUT"
+			}
+
 			rewindLine()
-			printLine(ERROR, item.Title, err.Error(), "ERROR")
+			printLine(ERROR, item.Title, err.Error(), label)
 			fmt.Println()
 			printBlock(item.Script, "Script")
 			printBlock(sout+"\n"+serr, "Command Output")
 			fmt.Println()
-			fmt.Printf("   Do you want to re-try? [Y/n] ")
 
-			c := readChar()
-			fmt.Printf("\x1B[1A")
-			rewindLine()
+			c := ""
+			for c == "" {
+				fmt.Printf("   Do you want to re-try? [Y/n/q] ")
+				switch readKey() {
+				case "y", "Y", "r", "R", "enter":
+					c = "retry"
+				case "n", "N":
+					c = "fail"
+				case "q", "Q":
+					c = "quit"
+				}
+				rewindLine()
+			}
 
 			switch c {
-			case "N", "n":
-				return false, res
+			case "fail":
+				res.Outcome = OutcomeFail
+				if res.TimedOut {
+					res.Outcome = OutcomeTimeout
+				}
+				return res
+			case "quit":
+				res.Outcome = OutcomeAborted
+				return res
 			}
 			continue
 		}
 
+		prompt := "OK? [Y/n/s/v/r/c/q] "
+	PromptLoop:
 		for {
 			rewindLine()
-			printLine(PROMPT, item.Title, Bold(sout), "OK? [Y/n/s/v] ")
-			c := readChar()
-			fmt.Printf("\x1B[1A")
+			printLine(PROMPT, item.Title, au.Bold(sout), prompt)
+			c := readKey()
+			prompt = "OK? [Y/n/s/v/r/c/q] "
 
 			switch c {
-			case "y", "Y", "":
+			case "y", "Y", "enter":
 				rewindLine()
 				printLine(SUCCESS, item.Title, sout, "PASS")
 				fmt.Println()
-				return true, res
+				res.Outcome = OutcomePass
+				return res
 
 			case "s", "S":
 				rewindLine()
 				printLine(SKIP, item.Title, sout, "SKIP")
 				fmt.Println()
-				return true, res
+				fmt.Printf("   Reason for skipping (optional): ")
+				res.Reason = readLine(false)
+				res.Outcome = OutcomeSkip
+				return res
 
 			case "v", "V":
 				fmt.Println()
@@ -273,11 +542,47 @@ func UxCheckItem(item *ChecklistItem, runner *Runner) (bool, CheckResult) {
 				fmt.Println()
 				continue
 
+			case "r", "R":
+				rewindLine()
+				break PromptLoop
+
+			case "c", "C":
+				copyToClipboard(sout)
+				prompt = "Copied! OK? [Y/n/s/v/r/c/q] "
+				continue
+
 			case "n", "N":
 				rewindLine()
 				printLine(ERROR, item.Title, sout, "FAIL")
 				fmt.Println()
-				return false, res
+				res.Outcome = OutcomeFail
+				if uxContinueOnFail {
+					return res
+				}
+
+				// Let the operator fix the issue out-of-band and check again
+				for {
+					fmt.Printf("   Fix the issue and re-check? [y/N/q] ")
+					c := readKey()
+					rewindLine()
+					switch c {
+					case "y", "Y", "r", "R":
+						break PromptLoop
+					case "n", "N", "enter":
+						return res
+					case "q", "Q":
+						// The item has failed all the same
+						res.Quit = true
+						return res
+					}
+				}
+
+			case "q", "Q":
+				rewindLine()
+				printLine(ERROR, item.Title, sout, "QUIT")
+				fmt.Println()
+				res.Outcome = OutcomeAborted
+				return res
 			}
 		}
 
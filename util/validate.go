@@ -0,0 +1,338 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/**
+ * An error in a specific field of a checklist item
+ */
+type ItemFieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *ItemFieldError) Error() string {
+	return e.Message
+}
+
+/**
+ * Checks that the given (fully expanded) item can be executed
+ */
+func ValidateItem(item *ChecklistItem) error {
+	if strings.TrimSpace(item.Script) == "" {
+		return &ItemFieldError{"script", fmt.Sprintf("Item '%s' has no script", item.Title)}
+	}
+
+	if item.Retries < 0 {
+		return &ItemFieldError{"retries", fmt.Sprintf("Item '%s' has a negative number of retries", item.Title)}
+	}
+
+	_, err := itemMatchers(item)
+	if err != nil {
+		return err
+	}
+
+	if item.ExpectStderr != "" {
+		_, err := regexMatcher(item.ExpectStderr, false)
+		if err != nil {
+			return &ItemFieldError{"expect_stderr", fmt.Sprintf("Item '%s' has an invalid expect_stderr regex: %s", item.Title, err.Error())}
+		}
+	}
+
+	return nil
+}
+
+/**
+ * A problem found while validating a checklist file
+ */
+type ValidationIssue struct {
+	Filename string
+	Line     int
+	Column   int
+	Message  string
+}
+
+func (i *ValidationIssue) String() string {
+	if i.Line == 0 {
+		return fmt.Sprintf("%s: %s", i.Filename, i.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", i.Filename, i.Line, i.Column, i.Message)
+}
+
+type checklistValidator struct {
+	issues  []ValidationIssue
+	visited map[string]bool
+
+	// Where the titles and ids of the items were first defined, across all
+	// the included files
+	titles map[string]itemPosition
+	ids    map[string]itemPosition
+}
+
+type itemPosition struct {
+	filename string
+	line     int
+}
+
+/**
+ * Validates the given checklist file and all the files it includes, returning
+ * all the problems found, with their position in the files
+ */
+func ValidateChecklistFile(filename string) []ValidationIssue {
+	v := &checklistValidator{
+		visited: make(map[string]bool),
+		titles:  make(map[string]itemPosition),
+		ids:     make(map[string]itemPosition),
+	}
+	v.validateFile(filename)
+
+	// If the structure is correct, make sure that the file can be loaded,
+	// catching errors in templates and dependencies
+	if len(v.issues) == 0 {
+		_, err := LoadChecklist(filename)
+		if err != nil {
+			v.issues = append(v.issues, ValidationIssue{Filename: filename, Message: err.Error()})
+		}
+	}
+
+	return v.issues
+}
+
+func (v *checklistValidator) report(filename string, node *yaml.Node, format string, args ...interface{}) {
+	issue := ValidationIssue{
+		Filename: filename,
+		Message:  fmt.Sprintf(format, args...),
+	}
+	if node != nil {
+		issue.Line = node.Line
+		issue.Column = node.Column
+	}
+	v.issues = append(v.issues, issue)
+}
+
+func (v *checklistValidator) validateFile(filename string) {
+	absFilename, err := filepath.Abs(filename)
+	if err == nil {
+		if v.visited[absFilename] {
+			return
+		}
+		v.visited[absFilename] = true
+	}
+
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		v.report(filename, nil, "Could not read file: %s", err.Error())
+		return
+	}
+
+	var doc yaml.Node
+	err = yaml.Unmarshal(content, &doc)
+	if err != nil {
+		v.report(filename, nil, "Could not parse file: %s", err.Error())
+		return
+	}
+	if len(doc.Content) == 0 {
+		v.report(filename, nil, "The file is empty")
+		return
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		v.report(filename, root, "Expecting a mapping at the top level")
+		return
+	}
+
+	v.validateKeys(filename, root, reflect.TypeOf(ChecklistFile{}))
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case "checklist":
+			if value.Kind != yaml.SequenceNode {
+				v.report(filename, value, "Expecting a list of items in `checklist`")
+				continue
+			}
+			for _, item := range value.Content {
+				v.validateItem(filename, item)
+			}
+
+		case "templates":
+			if value.Kind != yaml.MappingNode {
+				v.report(filename, value, "Expecting a mapping of templates in `templates`")
+				continue
+			}
+			for j := 1; j < len(value.Content); j += 2 {
+				v.validateKeys(filename, value.Content[j], reflect.TypeOf(ChecklistItem{}))
+			}
+
+		case "vars":
+			if value.Kind != yaml.MappingNode {
+				v.report(filename, value, "Expecting a mapping of variables in `vars`")
+				continue
+			}
+			for j := 1; j < len(value.Content); j += 2 {
+				v.validateKeys(filename, value.Content[j], reflect.TypeOf(VarSpec{}))
+				var spec VarSpec
+				err := value.Content[j].Decode(&spec)
+				if err != nil {
+					v.report(filename, value.Content[j], "Invalid variable %s: %s", value.Content[j-1].Value, err.Error())
+				}
+			}
+
+		case "notify":
+			if value.Kind != yaml.SequenceNode {
+				v.report(filename, value, "Expecting a list of notifiers in `notify`")
+				continue
+			}
+			for _, node := range value.Content {
+				v.validateKeys(filename, node, reflect.TypeOf(NotifySpec{}))
+				var spec NotifySpec
+				err := node.Decode(&spec)
+				if err == nil {
+					err = spec.Check()
+				}
+				if err != nil {
+					v.report(filename, node, "Invalid notifier: %s", err.Error())
+				}
+			}
+
+		case "include":
+			if value.Kind != yaml.SequenceNode {
+				v.report(filename, value, "Expecting a list of files in `include`")
+				continue
+			}
+			for _, inc := range value.Content {
+				path := inc.Value
+				if !filepath.IsAbs(path) {
+					path = filepath.Join(filepath.Dir(filename), path)
+				}
+				v.validateFile(path)
+			}
+		}
+	}
+}
+
+/**
+ * Reports the value if it was already used by another item, in this file or
+ * in another file of the checklist
+ */
+func (v *checklistValidator) checkUnique(seen map[string]itemPosition, what string, value string, filename string, node *yaml.Node) {
+	if value == "" {
+		return
+	}
+	first, ok := seen[value]
+	if !ok {
+		seen[value] = itemPosition{filename, node.Line}
+		return
+	}
+	if first.filename == filename {
+		v.report(filename, node, "Duplicate %s '%s' (first defined on line %d)", what, value, first.line)
+	} else {
+		v.report(filename, node, "Duplicate %s '%s' (first defined in %s on line %d)", what, value, first.filename, first.line)
+	}
+}
+
+func (v *checklistValidator) validateItem(filename string, node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		v.report(filename, node, "Expecting a mapping for the checklist item")
+		return
+	}
+	v.validateKeys(filename, node, reflect.TypeOf(ChecklistItem{}))
+
+	var item ChecklistItem
+	err := node.Decode(&item)
+	if err != nil {
+		v.report(filename, node, "%s", err.Error())
+		return
+	}
+
+	// The values with template arguments are only known once expanded
+	if item.Use == "" || !rxTemplateArg.MatchString(item.Title) {
+		v.checkUnique(v.titles, "title", item.Title, filename, node)
+	}
+	if item.Use == "" || !rxTemplateArg.MatchString(item.ID) {
+		v.checkUnique(v.ids, "id", item.ID, filename, node)
+	}
+
+	// Items using templates are validated after loading, when expanded
+	if item.Use != "" {
+		return
+	}
+	err = ValidateItem(&item)
+	if err != nil {
+		pos := node
+		if ferr, ok := err.(*ItemFieldError); ok {
+			if value := mappingValue(node, ferr.Field); value != nil {
+				pos = value
+			}
+		}
+		v.report(filename, pos, "%s", err.Error())
+	}
+}
+
+/**
+ * Reports all the keys of the mapping node that are not known in the given
+ * struct type
+ */
+func (v *checklistValidator) validateKeys(filename string, node *yaml.Node, t reflect.Type) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+
+	known := yamlFieldNames(t)
+	for i := 0; i < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if !known[key.Value] {
+			v.report(filename, key, "Unknown key `%s`", key.Value)
+		}
+	}
+}
+
+/**
+ * Returns the value node of the given key in a mapping node
+ */
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+/**
+ * Returns the exported fields of the given struct type that are read from
+ * YAML, by key
+ */
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "-" || field.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+/**
+ * Returns the YAML keys of the fields in the given struct type
+ */
+func yamlFieldNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool)
+	for name := range yamlFields(t) {
+		names[name] = true
+	}
+	return names
+}
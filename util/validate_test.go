@@ -0,0 +1,49 @@
+package util
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateDuplicatesAcrossIncludes(t *testing.T) {
+	dir, remove := writeTestFiles(t, map[string]string{
+		"main.yaml": `include: [common.yaml]
+checklist:
+  - title: Is the cluster reachable?
+    script: "true"
+  - title: Another check
+    id: cluster
+    script: "true"
+  - use: app
+    with: {app: web}
+  - use: app
+    with: {app: api}
+templates:
+  app:
+    title: Is {{app}} running?
+    script: "true"
+`,
+		"common.yaml": `checklist:
+  - title: Is the cluster reachable?
+    id: cluster
+    script: "true"
+`,
+	})
+	defer remove()
+
+	main := filepath.Join(dir, "main.yaml")
+	common := filepath.Join(dir, "common.yaml")
+	issues := ValidateChecklistFile(main)
+	expected := []ValidationIssue{
+		{Filename: main, Line: 3, Column: 5, Message: "Duplicate title 'Is the cluster reachable?' (first defined in " + common + " on line 2)"},
+		{Filename: main, Line: 5, Column: 5, Message: "Duplicate id 'cluster' (first defined in " + common + " on line 2)"},
+	}
+	if len(issues) != len(expected) {
+		t.Fatalf("Expecting %d issues, got %v", len(expected), issues)
+	}
+	for i := range expected {
+		if issues[i] != expected[i] {
+			t.Errorf("Expecting %s, got %s", expected[i].String(), issues[i].String())
+		}
+	}
+}
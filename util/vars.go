@@ -0,0 +1,284 @@
+package util
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A `${NAME}` or `${NAME:-default}` reference to another variable
+var rxVarRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// A value that is entirely a reference, which is told apart from a legacy
+// single-word command (ex. `${hostname}`) by the upper-case name
+var rxWholeVarRef = regexp.MustCompile(`^\$\{([A-Z_][A-Z0-9_]*)(:-([^}]*))?\}$`)
+
+// A `$NAME` or `${NAME` variable use in a shell command
+var rxShellVar = regexp.MustCompile(`\$\{?([A-Za-z_][A-Za-z0-9_]*)`)
+
+/**
+ * The definition of a checklist variable, given either as a plain value or
+ * as a mapping. For compatibility, the plain value `<` marks a variable that
+ * is required from the environment, and a plain value of `${<command>}` is
+ * replaced with the output of the command.
+ */
+type VarSpec struct {
+	Value       string   `yaml:"value"`
+	Default     string   `yaml:"default"`
+	Required    bool     `yaml:"required"`
+	Description string   `yaml:"description"`
+	Type        string   `yaml:"type"`
+	Values      []string `yaml:"values"`
+	Secret      bool     `yaml:"secret"`
+}
+
+func (s *VarSpec) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err == nil {
+		*s = VarSpec{Value: value}
+		if value == "<" {
+			*s = VarSpec{Required: true}
+		}
+		return nil
+	}
+
+	type plainVarSpec VarSpec
+	var spec plainVarSpec
+	err := unmarshal(&spec)
+	if err != nil {
+		return err
+	}
+	*s = VarSpec(spec)
+
+	switch s.Type {
+	case "", "string", "int", "url":
+	case "enum":
+		if len(s.Values) == 0 {
+			return fmt.Errorf("Expecting the allowed `values` of the enum")
+		}
+	default:
+		return fmt.Errorf("Unknown variable type '%s' (expecting string, int, url or enum)", s.Type)
+	}
+	return nil
+}
+
+/**
+ * Returns the shell command of a legacy `${<command>}` value, or "" if the
+ * value is not a command. A value with other references after the first one
+ * (ex. `${HOST}:${PORT}`) is not a command either.
+ */
+func (s *VarSpec) command() string {
+	if !strings.HasPrefix(s.Value, "${") || !strings.HasSuffix(s.Value, "}") {
+		return ""
+	}
+	if rxWholeVarRef.MatchString(s.Value) || rxVarRef.MatchString(s.Value[2:]) {
+		return ""
+	}
+	return s.Value[2 : len(s.Value)-1]
+}
+
+/**
+ * Returns the names of the other variables this variable refers to
+ */
+func (s *VarSpec) references() []string {
+	var names []string
+	if cmd := s.command(); cmd != "" {
+		for _, m := range rxShellVar.FindAllStringSubmatch(cmd, -1) {
+			names = append(names, m[1])
+		}
+		return names
+	}
+	for _, text := range []string{s.Value, s.Default} {
+		for _, m := range rxVarRef.FindAllStringSubmatch(text, -1) {
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+/**
+ * Checks that the given value is valid for the type of the variable
+ */
+func (s *VarSpec) Check(value string) error {
+	switch s.Type {
+	case "int":
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("Expecting an integer, got '%s'", value)
+		}
+	case "url":
+		u, err := url.Parse(value)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("Expecting a URL, got '%s'", value)
+		}
+	case "enum":
+		for _, allowed := range s.Values {
+			if allowed == value {
+				return nil
+			}
+		}
+		return fmt.Errorf("Expecting one of %v, got '%s'", s.Values, value)
+	}
+	return nil
+}
+
+/**
+ * Returns the names of the given variables in the order they must be
+ * resolved, so that every variable comes after the variables it refers to.
+ * Independent variables are ordered by name.
+ */
+func varOrder(vars map[string]VarSpec) ([]string, error) {
+	var names []string
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var order []string
+	state := make(map[string]int)
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("Variable cycle: %s", strings.Join(append(path, name), " -> "))
+		case 2:
+			return nil
+		}
+
+		state[name] = 1
+		spec := vars[name]
+		refs := spec.references()
+		sort.Strings(refs)
+		for _, ref := range refs {
+			if _, ok := vars[ref]; !ok || ref == name {
+				continue
+			}
+			err := visit(ref, append(path, name))
+			if err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		order = append(order, name)
+		return nil
+	}
+
+	for _, name := range names {
+		err := visit(name, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+/**
+ * Replaces the `${NAME}` and `${NAME:-default}` references in the given text
+ * with the resolved variables or the environment
+ */
+func (c *Config) expandVarRefs(text string) string {
+	return rxVarRef.ReplaceAllStringFunc(text, func(ref string) string {
+		m := rxVarRef.FindStringSubmatch(ref)
+		value := c.Env[m[1]]
+		if value == "" {
+			value = os.Getenv(m[1])
+		}
+		if value == "" {
+			value = m[3]
+		}
+		return value
+	})
+}
+
+/**
+ * Resolves the value of a single variable, taking it from the environment if
+ * it's defined there, or asking the operator if it's required and missing
+ */
+func (c *Config) resolveVar(name string, spec *VarSpec) (string, error) {
+	if value := os.Getenv(name); value != "" {
+		return value, nil
+	}
+
+	if command := spec.command(); command != "" {
+		cmd := exec.Command("bash", "-c", command)
+		cmd.Env = append(os.Environ(), c.GetEnvList()...)
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("Unable to execute '%s': %s", command, err.Error())
+		}
+		return strings.TrimRight(string(out), "\n\r\t "), nil
+	}
+
+	// Required variables without a value are confirmed by the operator, if
+	// possible, otherwise the default is used
+	value := c.expandVarRefs(spec.Value)
+	def := c.expandVarRefs(spec.Default)
+	if value == "" && spec.Required && c.PromptVar != nil {
+		for _, secret := range c.Secrets {
+			if secret == name {
+				spec.Secret = true
+			}
+		}
+		return c.PromptVar(name, spec, def), nil
+	}
+	if value == "" {
+		value = def
+	}
+	if value == "" && spec.Required {
+		if spec.Description != "" {
+			return "", fmt.Errorf("Missing required %s environment variable (%s)", name, spec.Description)
+		}
+		return "", fmt.Errorf("Missing required %s environment variable", name)
+	}
+	return value, nil
+}
+
+/**
+ * Resolves all the checklist variables into the environment of the scripts,
+ * in dependency order, validating their values. All the problems found are
+ * returned.
+ */
+func (c *Config) ResolveVars() []error {
+	order, err := varOrder(c.Vars)
+	if err != nil {
+		return []error{err}
+	}
+
+	var errs []error
+	for _, name := range order {
+		spec := c.Vars[name]
+		value, err := c.resolveVar(name, &spec)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if value != "" {
+			err = spec.Check(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("Invalid %s variable: %s", name, err.Error()))
+				continue
+			}
+		}
+
+		c.Env[name] = value
+		if spec.Secret {
+			AddSecret(value)
+		}
+	}
+
+	// Mask the values of the variables declared as secrets, either defined
+	// in the checklist or taken from the environment
+	for _, name := range c.Secrets {
+		value, ok := c.Env[name]
+		if !ok {
+			value = os.Getenv(name)
+		}
+		AddSecret(value)
+	}
+
+	return errs
+}
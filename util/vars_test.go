@@ -0,0 +1,107 @@
+package util
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+/**
+ * Resolves the given variables, returning the resolved environment and the
+ * errors
+ */
+func resolveTestVars(vars map[string]VarSpec) (map[string]string, []error) {
+	config, _ := CreateConfig()
+	config.Vars = vars
+	errs := config.ResolveVars()
+	return config.Env, errs
+}
+
+func TestResolveVars(t *testing.T) {
+	os.Setenv("PREFLIGHTER_TEST_HOST", "example.com")
+	defer os.Unsetenv("PREFLIGHTER_TEST_HOST")
+
+	env, errs := resolveTestVars(map[string]VarSpec{
+		"COMMAND":   {Value: "${echo from a command}"},
+		"SHELL_REF": {Value: "${echo $PORT}"},
+		"PORT":      {Value: "8080", Type: "int"},
+		"HOST":      {Value: "${PREFLIGHTER_TEST_HOST}"},
+		"ADDRESS":   {Value: "${HOST}:${PORT}"},
+		"URL":       {Value: "https://${ADDRESS}/", Type: "url"},
+		"FALLBACK":  {Value: "${PREFLIGHTER_TEST_UNDEFINED:-fallback}"},
+		"DEFAULTED": {Default: "${PORT}0"},
+		"EMPTY":     {},
+	})
+	if len(errs) != 0 {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+
+	expected := map[string]string{
+		"COMMAND":   "from a command",
+		"SHELL_REF": "8080",
+		"PORT":      "8080",
+		"HOST":      "example.com",
+		"ADDRESS":   "example.com:8080",
+		"URL":       "https://example.com:8080/",
+		"FALLBACK":  "fallback",
+		"DEFAULTED": "80800",
+		"EMPTY":     "",
+	}
+	for name, value := range expected {
+		if env[name] != value {
+			t.Errorf("Expecting %s=%q, got %q", name, value, env[name])
+		}
+	}
+}
+
+func TestResolveVarsEnvironment(t *testing.T) {
+	os.Setenv("PREFLIGHTER_TEST_PORT", "9090")
+	defer os.Unsetenv("PREFLIGHTER_TEST_PORT")
+
+	env, errs := resolveTestVars(map[string]VarSpec{
+		"PREFLIGHTER_TEST_PORT": {Value: "${exit 1}", Type: "int"},
+	})
+	if len(errs) != 0 || env["PREFLIGHTER_TEST_PORT"] != "9090" {
+		t.Errorf("Expecting the environment to take precedence, got %v (%v)", env, errs)
+	}
+}
+
+func TestResolveVarsErrors(t *testing.T) {
+	tests := []struct {
+		vars     map[string]VarSpec
+		expected string
+	}{
+		{map[string]VarSpec{"PORT": {Value: "http", Type: "int"}}, "Invalid PORT variable: Expecting an integer, got 'http'"},
+		{map[string]VarSpec{"PORT": {Default: "${OTHER:-x}", Type: "int"}}, "Expecting an integer, got 'x'"},
+		{map[string]VarSpec{"NAME": {Value: "${exit 3}"}}, "Unable to execute 'exit 3'"},
+		{map[string]VarSpec{"TOKEN": {Required: true, Description: "The token"}}, "Missing required TOKEN environment variable (The token)"},
+		{map[string]VarSpec{"A": {Value: "${B}"}, "B": {Value: "x${A}"}}, "Variable cycle: A -> B -> A"},
+	}
+
+	for _, test := range tests {
+		_, errs := resolveTestVars(test.vars)
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), test.expected) {
+			t.Errorf("%+v: expecting an error with %q, got %v", test.vars, test.expected, errs)
+		}
+	}
+}
+
+func TestVarSpecCommand(t *testing.T) {
+	tests := map[string]string{
+		"${hostname}":          "hostname",
+		"${dcos config show}":  "dcos config show",
+		"${echo $HOME}":        "echo $HOME",
+		"${HOME}":              "",
+		"${HOME:-/root}":       "",
+		"${HOST}:${PORT}":      "",
+		"${host}-${port}":      "",
+		"${HOST}${PORT:-8080}": "",
+		"plain":                "",
+	}
+	for value, expected := range tests {
+		spec := VarSpec{Value: value}
+		if command := spec.command(); command != expected {
+			t.Errorf("%s: expecting the command %q, got %q", value, expected, command)
+		}
+	}
+}